@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+func init() {
+	fnRegisterCommands = append(fnRegisterCommands, func(store state.Backend) []applicationCommand {
+		return []applicationCommand{
+			{
+				Command: &discordgo.ApplicationCommand{
+					Name:        "state-export",
+					Description: "Download a backup of everything the bot has stored for this server",
+				},
+				Permission: discordgo.PermissionAdministrator,
+				Handler: func(ctx context.Context, s session, i *discordgo.InteractionCreate, _ commandOptions) {
+					buf := bytes.Buffer{}
+					exported, err := state.Export(ctx, store, &buf, guildPrefix(i.GuildID), nil)
+					if err != nil {
+						log.Println("Could not export state:", err)
+						commandError(ctx, s, i.Interaction, err)
+						return
+					}
+
+					err = respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
+						Type: discordgo.InteractionResponseChannelMessageWithSource,
+						Data: &discordgo.InteractionResponseData{
+							Content: fmt.Sprintf("Exported %d values", exported),
+							Flags:   1 << 6, // Ephemeral, private
+							Files: []*discordgo.File{
+								{
+									Name:        fmt.Sprintf("lil-dumpster-%s-%s.json", i.GuildID, time.Now().UTC().Format("20060102-150405")),
+									ContentType: "application/json",
+									Reader:      &buf,
+								},
+							},
+						},
+					})
+					if err != nil {
+						log.Println("Could not respond to user message:", err)
+						commandError(ctx, s, i.Interaction, err)
+						return
+					}
+				},
+			},
+		}
+	})
+}
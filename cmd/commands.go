@@ -1,18 +1,29 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
 )
 
 type applicationCommand struct {
-	Command           *discordgo.ApplicationCommand
-	Autocomplete      func(s *discordgo.Session, i *discordgo.InteractionCreate, o *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice
-	MessageComponents map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate)
-	Handler           func(s *discordgo.Session, i *discordgo.InteractionCreate)
+	Command      *discordgo.ApplicationCommand
+	Autocomplete autocompleteHandler
+	// MessageComponents are keyed by the CustomID prefix they handle
+	MessageComponents map[string]componentHandler
+	Handler           commandHandler
+	// Subcommands are keyed by "subcommand" or "group subcommand", and replace
+	// Handler and Autocomplete for commands that have them
+	Subcommands map[string]subcommand
+	// Permission restricts the command to members with all of these permission
+	// bits, or a role allowed to use it with /permissions. Discord also hides
+	// the command from other members unless the guild overrides that.
+	Permission int64
 }
 
 type commandRegistration func(store state.Backend) []applicationCommand
@@ -20,79 +31,288 @@ type commandRegistration func(store state.Backend) []applicationCommand
 var fnRegisterCommands = []commandRegistration{}
 
 type Commands struct {
-	commands []applicationCommand
-	s        *discordgo.Session
+	commands   []applicationCommand
+	router     *router
+	handler    interactionHandler
+	gateway    *discordgo.Session
+	s          session
+	store      state.Backend
+	leader     *state.Leader
+	purgeGrace time.Duration
+	global     bool
+
+	// initialized holds the guilds set up since the gateway session began
+	initMu      sync.Mutex
+	initialized map[string]bool
+
+	// rolesMu keeps the leader's sweep of every guild and the setup of a
+	// newly available guild from both posting a roles message
+	rolesMu sync.Mutex
+
+	// ctx is the parent of every event's context. It outlives the signal to
+	// shut down so that events in flight may finish, and is cancelled once
+	// Shutdown gives up waiting for them.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lifeMu   sync.Mutex
+	closing  bool
+	inflight sync.WaitGroup
 }
 
-func NewCommands(session *discordgo.Session, store state.Backend) *Commands {
+// Option customizes the behavior of Commands.
+type Option func(c *Commands)
+
+const (
+	// leaderTTL is how long a replica may go without renewing its leadership
+	// before another replica takes over its background work.
+	leaderTTL = 30 * time.Second
+
+	// defaultPurgeGrace is how long a guild's state is kept after the bot is
+	// removed from it.
+	defaultPurgeGrace = 72 * time.Hour
+
+	// eventTimeout bounds the time spent handling each gateway event or
+	// interaction, including any deferred response.
+	eventTimeout = 30 * time.Second
+)
+
+func NewCommands(gateway *discordgo.Session, store state.Backend, opts ...Option) *Commands {
+	c := newCommands(discordSession{gateway}, store, opts...)
+	c.gateway = gateway
+	return c
+}
+
+// newCommands builds Commands around any session, such as a fake in tests.
+func newCommands(s session, store state.Backend, opts ...Option) *Commands {
 	ret := Commands{
-		commands: []applicationCommand{},
-		s:        session,
+		commands:    []applicationCommand{},
+		s:           s,
+		store:       store,
+		leader:      state.NewLeader(store, "leader/background", leaderTTL),
+		purgeGrace:  defaultPurgeGrace,
+		initialized: map[string]bool{},
+	}
+	ret.ctx, ret.cancel = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		opt(&ret)
 	}
 
 	for _, fn := range fnRegisterCommands {
 		ret.commands = append(ret.commands, fn(store)...)
 	}
+	ret.commands = append(ret.commands, ret.permissionsCommand())
+	ret.router = newRouter(ret.commands, store)
+	ret.handler = chain(ret.router.route, withLogging, withDeferral(deferAfter), withRecovery)
 
 	return &ret
 }
 
+// WithPurgeGrace sets how long a guild's state is kept after the bot has been
+// removed from it, in case it is invited back.
+func WithPurgeGrace(grace time.Duration) Option {
+	return func(c *Commands) {
+		c.purgeGrace = grace
+	}
+}
+
+// WithGlobalCommands registers the application commands once for every guild
+// instead of separately in each guild. Discord may take a while to propagate
+// changes to global commands.
+func WithGlobalCommands() Option {
+	return func(c *Commands) {
+		c.global = true
+	}
+}
+
 func (c *Commands) AddHandlers() {
-	c.s.AddHandler(c.handleReady)
-	c.s.AddHandler(c.handleCommand)
+	c.gateway.AddHandler(func(s *discordgo.Session, event *discordgo.Ready) {
+		c.handleReady(discordSession{s}, event)
+	})
+	c.gateway.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		c.handleCommand(discordSession{s}, i)
+	})
+	c.gateway.AddHandler(func(s *discordgo.Session, event *discordgo.GuildCreate) {
+		c.handleGuildCreate(discordSession{s}, event)
+	})
+	c.gateway.AddHandler(func(s *discordgo.Session, event *discordgo.GuildDelete) {
+		c.handleGuildDelete(discordSession{s}, event)
+	})
+	c.gateway.AddHandler(func(s *discordgo.Session, event *discordgo.ChannelDelete) {
+		c.handleChannelDelete(discordSession{s}, event)
+	})
 }
 
-func (c *Commands) handleReady(s *discordgo.Session, event *discordgo.Ready) {
-	for _, g := range event.Guilds {
-		if err := manageRoles(s, g); err != nil {
+// Run campaigns for leadership among the bot replicas sharing the store until
+// ctx is done, performing background work only while elected.
+func (c *Commands) Run(ctx context.Context) {
+	c.leader.Run(ctx, c.runBackground)
+}
+
+// Shutdown stops handling new events and waits for those in flight to finish.
+// If ctx ends first, their contexts are cancelled and its error is returned
+// once they have returned.
+func (c *Commands) Shutdown(ctx context.Context) error {
+	c.lifeMu.Lock()
+	c.closing = true
+	c.lifeMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.cancel()
+	<-done
+	return err
+}
+
+// begin tracks an event until the returned func is called, returning the
+// context in which to handle it. Events arriving during shutdown are refused.
+func (c *Commands) begin() (context.Context, func(), bool) {
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+
+	if c.closing {
+		return nil, nil, false
+	}
+
+	c.inflight.Add(1)
+	ctx, cancel := context.WithTimeout(c.ctx, eventTimeout)
+	return ctx, func() {
+		cancel()
+		c.inflight.Done()
+	}, true
+}
+
+// runBackground performs the work that only the leader should do. It is called
+// each time this replica is elected, with a context that ends with its term.
+func (c *Commands) runBackground(ctx context.Context) {
+	if err := migrateGuildKeys(ctx, c.s, c.store); err != nil {
+		log.Println("Failed to move rotations into their guilds:", err)
+	}
+
+	// Guilds received before the election were skipped by handleReady
+	for _, g := range c.s.Guilds() {
+		c.rolesMu.Lock()
+		err := manageRoles(ctx, c.s, g)
+		c.rolesMu.Unlock()
+		if err != nil {
 			log.Println("Failed to watch guild:", err)
 		}
+	}
+
+	c.runPurges(ctx)
+}
+
+func (c *Commands) handleReady(s session, event *discordgo.Ready) {
+	// A new session may follow an outage, so set every guild up again
+	c.initMu.Lock()
+	c.initialized = map[string]bool{}
+	c.initMu.Unlock()
+
+	// Commands in whichever scope isn't in use are removed, in case the bot
+	// was switched between global and per-guild registration
+	global := []*discordgo.ApplicationCommand{}
+	if c.global {
+		global = c.applicationCommands()
+	}
+
+	ctx, done, ok := c.begin()
+	if !ok {
+		return
+	}
+	err := registerCommands(ctx, s, "", global)
+	done()
+	if err != nil {
+		log.Println("Failed to register global commands:", err)
+	}
+
+	for _, g := range event.Guilds {
+		c.setupGuild(s, g.ID)
+	}
+}
+
+// setupGuild registers the commands and roles message in a guild, unless that
+// has already been done in this session.
+func (c *Commands) setupGuild(s session, guildID string) {
+	ctx, done, ok := c.begin()
+	if !ok {
+		return
+	}
+	defer done()
+
+	c.initMu.Lock()
+	if c.initialized[guildID] {
+		c.initMu.Unlock()
+		return
+	}
+	c.initialized[guildID] = true
+	c.initMu.Unlock()
 
-		for _, cmd := range c.commands {
-			fmt.Printf("Registering application command %q for bot user %q in guild %q\n", cmd.Command.Name, s.State.User.ID, g.ID)
-			if _, err := s.ApplicationCommandCreate(s.State.User.ID, g.ID, cmd.Command); err != nil {
-				log.Printf("Unable to set application command %q: %s", cmd.Command.Name, err)
-			}
+	if c.leader.IsLeader() {
+		c.rolesMu.Lock()
+		err := manageRoles(ctx, s, &discordgo.Guild{ID: guildID})
+		c.rolesMu.Unlock()
+		if err != nil {
+			log.Println("Failed to watch guild:", err)
 		}
 	}
+
+	guild := []*discordgo.ApplicationCommand{}
+	if !c.global {
+		guild = c.applicationCommands()
+	}
+	if err := registerCommands(ctx, s, guildID, guild); err != nil {
+		log.Println("Failed to register guild commands:", err)
+
+		// Try again the next time the guild becomes available
+		c.forgetGuild(guildID)
+	}
+}
+
+// forgetGuild allows a guild to be set up again, such as after the bot was
+// removed from it.
+func (c *Commands) forgetGuild(guildID string) {
+	c.initMu.Lock()
+	delete(c.initialized, guildID)
+	c.initMu.Unlock()
 }
 
-func (c *Commands) handleCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+// applicationCommands returns the definitions of every command to register.
+func (c *Commands) applicationCommands() []*discordgo.ApplicationCommand {
+	ret := []*discordgo.ApplicationCommand{}
 	for _, cmd := range c.commands {
-		switch i.Type {
-		case discordgo.InteractionApplicationCommand:
-			if cmd.Command.Name == i.ApplicationCommandData().Name {
-				cmd.Handler(s, i)
-			}
-		case discordgo.InteractionApplicationCommandAutocomplete:
-			if cmd.Autocomplete != nil && cmd.Command.Name == i.ApplicationCommandData().Name {
-				for _, opt := range i.ApplicationCommandData().Options {
-					if opt.Focused {
-						choices := cmd.Autocomplete(s, i, opt)
-						_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-							Type: discordgo.InteractionApplicationCommandAutocompleteResult,
-							Data: &discordgo.InteractionResponseData{Choices: choices},
-						})
-					}
-				}
-			}
-		case discordgo.InteractionMessageComponent:
-			if cmd.MessageComponents != nil {
-				log.Println(i.MessageComponentData().CustomID)
-				for customID, fn := range cmd.MessageComponents {
-					if customID == i.MessageComponentData().CustomID {
-						fn(s, i)
-					}
-				}
-			}
-		default:
-			log.Println("Unknown interaction type encountered: ", i.Type)
+		command := *cmd.Command
+		if cmd.Permission != 0 {
+			command.DefaultMemberPermissions = &cmd.Permission
 		}
+		ret = append(ret, &command)
+	}
+
+	return ret
+}
+
+func (c *Commands) handleCommand(s session, i *discordgo.InteractionCreate) {
+	ctx, done, ok := c.begin()
+	if !ok {
+		return
 	}
+	defer done()
+
+	c.handler(ctx, s, i)
 }
 
-func commandError(s *discordgo.Session, i *discordgo.Interaction, message error) {
-	_ = s.InteractionRespond(i, &discordgo.InteractionResponse{
+func commandError(ctx context.Context, s session, i *discordgo.Interaction, message error) {
+	_ = respond(ctx, s, i, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Content: fmt.Sprintf(":warning: %s", message),
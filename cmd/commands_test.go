@@ -1,9 +1,20 @@
 package cmd
 
-import "github.com/bwmarrin/discordgo"
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
 
 type mockDiscordSession struct {
 	mockUser               func(userID string, opt ...discordgo.RequestOption) (st *discordgo.User, err error)
+	mockChannel            func(channelID string, opt ...discordgo.RequestOption) (st *discordgo.Channel, err error)
 	mockInteractionRespond func(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse) error
 }
 
@@ -14,3 +25,163 @@ func (m *mockDiscordSession) User(userID string, opt ...discordgo.RequestOption)
 func (m *mockDiscordSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse) error {
 	return m.mockInteractionRespond(interaction, resp)
 }
+
+func (m *mockDiscordSession) Channel(channelID string, opt ...discordgo.RequestOption) (st *discordgo.Channel, err error) {
+	return m.mockChannel(channelID, opt...)
+}
+
+func TestCommands_Shutdown(t *testing.T) {
+	c := NewCommands(nil, state.NewMemory())
+
+	ctx, done, ok := c.begin()
+	if !ok {
+		t.Fatal("begin() refused an event before shutdown")
+	}
+
+	// A slow event is cancelled once the shutdown deadline passes
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		done()
+	}()
+	if err := c.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if _, _, ok := c.begin(); ok {
+		t.Error("begin() accepted an event after shutdown")
+	}
+}
+
+func TestCommands_Shutdown_waits(t *testing.T) {
+	c := NewCommands(nil, state.NewMemory())
+
+	ctx, done, _ := c.begin()
+	finished := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		if ctx.Err() == nil {
+			close(finished)
+		}
+		done()
+	}()
+
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("Shutdown() cancelled an event that was finishing")
+	}
+}
+
+func TestCommands_setup(t *testing.T) {
+	f := newFakeGuild()
+	roles := f.addChannel("roles")
+	f.commands[""] = []*discordgo.ApplicationCommand{{ID: "1", Name: "stale-global"}}
+	f.commands[f.id] = []*discordgo.ApplicationCommand{{ID: "2", Name: "rotator-advance"}}
+
+	c := newCommands(f, state.NewMemory())
+	c.handleReady(f, &discordgo.Ready{Guilds: []*discordgo.Guild{{ID: f.id}}})
+
+	want := []string{}
+	for _, cmd := range c.applicationCommands() {
+		want = append(want, cmd.Name)
+	}
+	sort.Strings(want)
+
+	if got := f.registered(f.id); !reflect.DeepEqual(got, want) {
+		t.Errorf("guild commands = %v, want %v", got, want)
+	}
+	if got := f.registered(""); len(got) != 0 {
+		t.Errorf("global commands = %v, want none", got)
+	}
+
+	// Not yet elected, so the roles message is left to the leader
+	if got := f.pinned(roles.ID); len(got) != 0 {
+		t.Errorf("pinned = %v, want nothing before election", got)
+	}
+
+	// Joining the same guild again in this session doesn't register again
+	f.commands[f.id] = nil
+	c.handleGuildCreate(f, &discordgo.GuildCreate{Guild: &discordgo.Guild{ID: f.id}})
+	if got := f.registered(f.id); len(got) != 0 {
+		t.Errorf("guild commands = %v after rejoining, want them left alone", got)
+	}
+
+	// Until the bot is removed from it
+	c.handleGuildDelete(f, &discordgo.GuildDelete{Guild: &discordgo.Guild{ID: f.id}})
+	c.handleGuildCreate(f, &discordgo.GuildCreate{Guild: &discordgo.Guild{ID: f.id}})
+	if got := f.registered(f.id); !reflect.DeepEqual(got, want) {
+		t.Errorf("guild commands = %v after being re-invited, want %v", got, want)
+	}
+}
+
+// flakyRegistration fails to register commands, as Discord does during an
+// outage.
+type flakyRegistration struct {
+	*fakeGuild
+}
+
+func (f flakyRegistration) ApplicationCommandBulkOverwrite(appID, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	return nil, errors.New(`HTTP 503 Service Unavailable`)
+}
+
+func TestCommands_guildEvents(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGuild()
+	store := state.NewMemory()
+	c := newCommands(f, store)
+	want := len(c.applicationCommands())
+
+	registered := func(guildID string) int {
+		return len(f.registered(guildID))
+	}
+	guild := func(id string, unavailable bool) *discordgo.Guild {
+		return &discordgo.Guild{ID: id, Unavailable: unavailable}
+	}
+
+	// A guild that is still unavailable isn't set up yet
+	c.handleGuildCreate(f, &discordgo.GuildCreate{Guild: guild(f.id, true)})
+	if got := registered(f.id); got != 0 {
+		t.Errorf("registered %d commands in an unavailable guild, want none", got)
+	}
+
+	// A failed registration is tried again when the guild next becomes available
+	c.handleGuildCreate(flakyRegistration{f}, &discordgo.GuildCreate{Guild: guild(f.id, false)})
+	if got := registered(f.id); got != 0 {
+		t.Fatalf("registered %d commands despite the failure", got)
+	}
+	c.handleGuildCreate(f, &discordgo.GuildCreate{Guild: guild(f.id, false)})
+	if got := registered(f.id); got != want {
+		t.Errorf("registered %d commands after retrying, want %d", got, want)
+	}
+
+	// An outage doesn't count as leaving, so the guild isn't set up again or
+	// scheduled for purging
+	f.commands[f.id] = nil
+	c.handleGuildDelete(f, &discordgo.GuildDelete{Guild: guild(f.id, true)})
+	c.handleGuildCreate(f, &discordgo.GuildCreate{Guild: guild(f.id, false)})
+	if got := registered(f.id); got != 0 {
+		t.Errorf("registered %d commands after an outage, want them left alone", got)
+	}
+	if keys, _ := store.List(ctx, purgePrefix); len(keys) != 0 {
+		t.Errorf("scheduled purges = %v after an outage, want none", keys)
+	}
+
+	// Being removed schedules a purge, which a re-invite cancels while
+	// registering the commands again
+	c.handleGuildDelete(f, &discordgo.GuildDelete{Guild: guild(f.id, false)})
+	if keys, _ := store.List(ctx, purgePrefix); len(keys) != 1 {
+		t.Errorf("scheduled purges = %v after removal, want one", keys)
+	}
+	c.handleGuildCreate(f, &discordgo.GuildCreate{Guild: guild(f.id, false)})
+	if got := registered(f.id); got != want {
+		t.Errorf("registered %d commands after a re-invite, want %d", got, want)
+	}
+	if keys, _ := store.List(ctx, purgePrefix); len(keys) != 0 {
+		t.Errorf("scheduled purges = %v after a re-invite, want none", keys)
+	}
+}
@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeGuild is an in-memory stand-in for Discord holding a single guild. It
+// implements session, applying each call to its state so that tests can check
+// the outcome of a handler rather than the calls it made.
+type fakeGuild struct {
+	mu sync.Mutex
+
+	id       string
+	bot      *discordgo.User
+	users    map[string]*discordgo.User
+	members  map[string]*discordgo.Member
+	roles    []*discordgo.Role
+	channels []*discordgo.Channel
+	messages map[string][]*discordgo.Message
+	pins     map[string][]string
+	// commands are the registered application commands by guild, with global
+	// commands under ""
+	commands map[string][]*discordgo.ApplicationCommand
+	// responses are the replies to each interaction by interaction ID
+	responses map[string][]fakeResponse
+	// unassignable roles are above the bot's own, so it may not manage them
+	unassignable map[string]bool
+
+	nextID int
+}
+
+// fakeResponse is one reply to an interaction.
+type fakeResponse struct {
+	// Kind is "respond", "edit", "delete" or "followup"
+	Kind       string
+	Type       discordgo.InteractionResponseType
+	Content    string
+	Flags      discordgo.MessageFlags
+	Components []discordgo.MessageComponent
+	Choices    []*discordgo.ApplicationCommandOptionChoice
+}
+
+var errFakeNotFound = errors.New(`HTTP 404 Not Found, {"message": "Unknown", "code": 10000}`)
+
+func newFakeGuild() *fakeGuild {
+	f := &fakeGuild{
+		id:           "1",
+		bot:          &discordgo.User{ID: "100", Username: "lil-dumpster", Bot: true},
+		users:        map[string]*discordgo.User{},
+		members:      map[string]*discordgo.Member{},
+		messages:     map[string][]*discordgo.Message{},
+		pins:         map[string][]string{},
+		commands:     map[string][]*discordgo.ApplicationCommand{},
+		responses:    map[string][]fakeResponse{},
+		unassignable: map[string]bool{},
+		nextID:       1000,
+	}
+	f.users[f.bot.ID] = f.bot
+
+	return f
+}
+
+func (f *fakeGuild) newID() string {
+	f.nextID++
+	return strconv.Itoa(f.nextID)
+}
+
+// addMember adds a member with the given permissions to the guild.
+func (f *fakeGuild) addMember(id, username string, permissions int64) *discordgo.Member {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	user := &discordgo.User{ID: id, Username: username}
+	f.users[id] = user
+	f.members[id] = &discordgo.Member{GuildID: f.id, User: user, Permissions: permissions, Roles: []string{}}
+	return f.members[id]
+}
+
+func (f *fakeGuild) addRole(name string) *discordgo.Role {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	role := &discordgo.Role{ID: f.newID(), Name: name}
+	f.roles = append(f.roles, role)
+	return role
+}
+
+func (f *fakeGuild) addChannel(name string) *discordgo.Channel {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	channel := &discordgo.Channel{ID: f.newID(), GuildID: f.id, Name: name, Type: discordgo.ChannelTypeGuildText}
+	f.channels = append(f.channels, channel)
+	return channel
+}
+
+// memberRoles returns the IDs of a member's roles.
+func (f *fakeGuild) memberRoles(userID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.members[userID].Roles...)
+}
+
+// pinned returns the content of the messages pinned in a channel.
+func (f *fakeGuild) pinned(channelID string) []string {
+	messages, _ := f.ChannelMessagesPinned(channelID)
+
+	ret := []string{}
+	for _, m := range messages {
+		ret = append(ret, m.Content)
+	}
+	return ret
+}
+
+// replies returns the replies to an interaction.
+func (f *fakeGuild) replies(interactionID string) []fakeResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]fakeResponse{}, f.responses[interactionID]...)
+}
+
+// registered returns the names of the commands registered in the guild, or
+// globally for "".
+func (f *fakeGuild) registered(guildID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ret := []string{}
+	for _, cmd := range f.commands[guildID] {
+		ret = append(ret, cmd.Name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (f *fakeGuild) BotUser() *discordgo.User {
+	return f.bot
+}
+
+func (f *fakeGuild) Guilds() []*discordgo.Guild {
+	return []*discordgo.Guild{{ID: f.id}}
+}
+
+func (f *fakeGuild) User(userID string, options ...discordgo.RequestOption) (*discordgo.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, ok := f.users[userID]; ok {
+		return user, nil
+	}
+	return nil, errFakeNotFound
+}
+
+func (f *fakeGuild) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, channel := range f.channels {
+		if channel.ID == channelID {
+			return channel, nil
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (f *fakeGuild) GuildRoles(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Role, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if guildID != f.id {
+		return nil, errFakeNotFound
+	}
+	return append([]*discordgo.Role{}, f.roles...), nil
+}
+
+func (f *fakeGuild) GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if guildID != f.id {
+		return nil, errFakeNotFound
+	}
+	return append([]*discordgo.Channel{}, f.channels...), nil
+}
+
+func (f *fakeGuild) GuildMemberRoleAdd(guildID, userID, roleID string, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	member, ok := f.members[userID]
+	if !ok || guildID != f.id {
+		return errFakeNotFound
+	}
+	if f.unassignable[roleID] {
+		return errors.New(`HTTP 403 Forbidden, {"message": "Missing Permissions", "code": 50013}`)
+	}
+
+	for _, role := range member.Roles {
+		if role == roleID {
+			return nil
+		}
+	}
+	member.Roles = append(member.Roles, roleID)
+	return nil
+}
+
+func (f *fakeGuild) GuildMemberRoleRemove(guildID, userID, roleID string, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	member, ok := f.members[userID]
+	if !ok || guildID != f.id {
+		return errFakeNotFound
+	}
+	if f.unassignable[roleID] {
+		return errors.New(`HTTP 403 Forbidden, {"message": "Missing Permissions", "code": 50013}`)
+	}
+
+	roles := []string{}
+	for _, role := range member.Roles {
+		if role != roleID {
+			roles = append(roles, role)
+		}
+	}
+	member.Roles = roles
+	return nil
+}
+
+func (f *fakeGuild) ChannelMessagesPinned(channelID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ret := []*discordgo.Message{}
+	for _, id := range f.pins[channelID] {
+		for _, m := range f.messages[channelID] {
+			if m.ID == id {
+				ret = append(ret, m)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (f *fakeGuild) ChannelMessageSend(channelID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := &discordgo.Message{ID: f.newID(), ChannelID: channelID, Content: content, Author: f.bot}
+	f.messages[channelID] = append(f.messages[channelID], m)
+	return m, nil
+}
+
+func (f *fakeGuild) ChannelMessageEdit(channelID, messageID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, m := range f.messages[channelID] {
+		if m.ID == messageID {
+			m.Content = content
+			return m, nil
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (f *fakeGuild) ChannelMessagePin(channelID, messageID string, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pins[channelID] = append(f.pins[channelID], messageID)
+	return nil
+}
+
+func (f *fakeGuild) ApplicationCommands(appID, guildID string, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*discordgo.ApplicationCommand{}, f.commands[guildID]...), nil
+}
+
+func (f *fakeGuild) ApplicationCommandBulkOverwrite(appID, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	registered := []*discordgo.ApplicationCommand{}
+	for _, cmd := range commands {
+		created := *cmd
+		created.ID, created.ApplicationID, created.GuildID = f.newID(), appID, guildID
+		registered = append(registered, &created)
+	}
+	f.commands[guildID] = registered
+	return registered, nil
+}
+
+func (f *fakeGuild) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.responses[interaction.ID]) > 0 {
+		return fmt.Errorf(`HTTP 400 Bad Request, {"message": "Interaction has already been acknowledged.", "code": 40060}`)
+	}
+
+	reply := fakeResponse{Kind: "respond", Type: resp.Type}
+	if resp.Data != nil {
+		reply.Content, reply.Flags, reply.Components, reply.Choices = resp.Data.Content, resp.Data.Flags, resp.Data.Components, resp.Data.Choices
+	}
+	f.responses[interaction.ID] = append(f.responses[interaction.ID], reply)
+	return nil
+}
+
+func (f *fakeGuild) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := fakeResponse{Kind: "edit"}
+	if newresp.Content != nil {
+		reply.Content = *newresp.Content
+	}
+	if newresp.Components != nil {
+		reply.Components = *newresp.Components
+	}
+	f.responses[interaction.ID] = append(f.responses[interaction.ID], reply)
+	return &discordgo.Message{Content: reply.Content}, nil
+}
+
+func (f *fakeGuild) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.responses[interaction.ID] = append(f.responses[interaction.ID], fakeResponse{Kind: "delete"})
+	return nil
+}
+
+func (f *fakeGuild) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := fakeResponse{Kind: "followup", Content: data.Content, Flags: data.Flags, Components: data.Components}
+	f.responses[interaction.ID] = append(f.responses[interaction.ID], reply)
+	return &discordgo.Message{Content: data.Content}, nil
+}
+
+// command builds a slash command interaction sent by the member.
+func (f *fakeGuild) command(member *discordgo.Member, channelID, name string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        f.newID(),
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   f.id,
+		ChannelID: channelID,
+		Member:    member,
+		Data:      discordgo.ApplicationCommandInteractionData{Name: name, Options: opts},
+	}}
+}
+
+// subcommandOption invokes a subcommand with options.
+func subcommandOption(name string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionSubCommand, Options: opts}
+}
+
+func userOption(name, userID string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionUser, Value: userID}
+}
+
+func stringOption(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+func boolOption(name string, value bool) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionBoolean, Value: value}
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+type guildSession interface {
+	Channel(channelID string, opt ...discordgo.RequestOption) (st *discordgo.Channel, err error)
+}
+
+// guildPrefix is the key prefix beneath which all of a guild's state lives.
+// Direct messages have no guild and share the "@me" namespace.
+func guildPrefix(guildID string) string {
+	if guildID == "" {
+		guildID = "@me"
+	}
+
+	return fmt.Sprintf("guild/%s/", guildID)
+}
+
+// guildStore scopes the store to a single guild.
+func guildStore(store state.Backend, guildID string) state.Backend {
+	return state.NewPrefixed(store, guildPrefix(guildID))
+}
+
+// errAlreadyMoved aborts moving a key whose new location is already taken.
+var errAlreadyMoved = errors.New("key already exists in the guild")
+
+// migrateGuildKeys moves rotations stored before state was namespaced by guild
+// into their guild's namespace. Rotations whose channel can no longer be found,
+// or whose guild already has a rotation for the channel, are left in place.
+// Rotator commands also move their channel's rotation when first used, so one
+// skipped here isn't hidden until the next election.
+func migrateGuildKeys(ctx context.Context, s guildSession, store state.Backend) error {
+	keys, err := store.List(ctx, "rotator/")
+	if err != nil {
+		return fmt.Errorf("unable to list rotations: %w", err)
+	}
+
+	for _, key := range keys {
+		channelID, _, ok := strings.Cut(strings.TrimPrefix(key, "rotator/"), "/")
+		if !ok {
+			continue
+		}
+
+		channel, err := s.Channel(channelID, discordgo.WithContext(ctx))
+		if err != nil {
+			log.Printf("Unable to find channel for %q, leaving it in place: %s", key, err)
+			continue
+		}
+
+		moved, err := moveLegacyKey(ctx, store, channel.GuildID, key)
+		if errors.Is(err, errAlreadyMoved) {
+			log.Printf("Not moving %q: guild %q already has its own copy, leaving both in place", key, channel.GuildID)
+			continue
+		} else if err != nil {
+			return err
+		} else if !moved {
+			continue
+		}
+
+		log.Printf("Moved %q into guild %q", key, channel.GuildID)
+	}
+
+	return nil
+}
+
+// moveLegacyKey moves a key stored before state was namespaced by guild into
+// the guild's namespace, reporting whether there was anything to move. Data
+// already written to the new location, such as by a command run before the
+// migration, is never overwritten: errAlreadyMoved is returned and both are
+// kept for an operator to reconcile.
+func moveLegacyKey(ctx context.Context, store state.Backend, guildID, key string) (bool, error) {
+	data := json.RawMessage{}
+	if err := store.Get(ctx, key, &data); errors.Is(err, state.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to read %q: %w", key, err)
+	}
+
+	moved := json.RawMessage{}
+	err := guildStore(store, guildID).Update(ctx, key, &moved, state.NoExpiry, func() error {
+		if moved != nil {
+			return errAlreadyMoved
+		}
+		moved = data
+		return nil
+	})
+	if errors.Is(err, errAlreadyMoved) {
+		return false, err
+	} else if err != nil {
+		return false, fmt.Errorf("unable to move %q: %w", key, err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		return false, fmt.Errorf("unable to remove %q: %w", key, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+func Test_migrateGuildKeys(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewMemory()
+	store.Set(ctx, "rotator/100/rotation", rotation{Current: 1}, state.NoExpiry)
+	store.Set(ctx, "rotator/200/rotation", rotation{Current: 2}, state.NoExpiry)
+	store.Set(ctx, "rotator/300/rotation", rotation{Current: 3}, state.NoExpiry)
+	store.Set(ctx, "guild/1/rotator/200/rotation", rotation{Current: 20}, state.NoExpiry)
+
+	s := &mockDiscordSession{
+		mockChannel: func(channelID string, opt ...discordgo.RequestOption) (*discordgo.Channel, error) {
+			switch channelID {
+			case "100", "200":
+				return &discordgo.Channel{ID: channelID, GuildID: "1"}, nil
+			default:
+				return nil, errors.New("unknown channel")
+			}
+		},
+	}
+
+	if err := migrateGuildKeys(ctx, s, store); err != nil {
+		t.Fatalf("migrateGuildKeys() error = %v", err)
+	}
+
+	keys, _ := store.List(ctx, "")
+	// The legacy rotation for 200 is kept rather than lost, as the guild
+	// already has its own
+	want := []string{"guild/1/rotator/100/rotation", "guild/1/rotator/200/rotation", "rotator/200/rotation", "rotator/300/rotation"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("migrateGuildKeys() keys = %v, want %v", keys, want)
+	}
+
+	tests := map[string]int{
+		"guild/1/rotator/100/rotation": 1,
+		"guild/1/rotator/200/rotation": 20,
+		"rotator/200/rotation":         2,
+	}
+	for key, want := range tests {
+		got := rotation{}
+		store.Get(ctx, key, &got)
+		if got.Current != want {
+			t.Errorf("migrateGuildKeys() %s Current = %d, want %d", key, got.Current, want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxInteractionSize bounds the body of a request to the interactions endpoint.
+const maxInteractionSize = 1 << 20
+
+// maxInteractionAge is how far the signed timestamp of a request may be from
+// now, so that a captured request can't be replayed later.
+const maxInteractionAge = 30 * time.Second
+
+// unacknowledgedRetries and unacknowledgedRetryDelay bound how long httpSession
+// waits for Discord to receive the initial response to an interaction before
+// giving up on editing or following up on it.
+const (
+	unacknowledgedRetries    = 5
+	unacknowledgedRetryDelay = 250 * time.Millisecond
+)
+
+// errNoResponse is returned by httpSession when the request that delivered an
+// interaction is gone, so its response can no longer be sent.
+var errNoResponse = errors.New("the interaction request is no longer waiting for a response")
+
+// errInlineFiles is returned by httpSession for an initial response with files,
+// which must be deferred and sent as an edit instead.
+var errInlineFiles = errors.New("files can't be sent in the initial response to an HTTP interaction")
+
+// StartHTTP prepares the commands to receive interactions from
+// InteractionsHandler instead of the gateway. Without a gateway session the
+// bot's user and guilds are looked up once, and commands registered in them,
+// in place of the Ready event. Guilds joined or left later are not noticed
+// until the bot restarts, and as guild and channel deletions never arrive,
+// their state is never purged.
+func (c *Commands) StartHTTP(ctx context.Context) error {
+	user, err := c.gateway.User("@me", discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("could not look up the bot user: %w", err)
+	}
+
+	ready := &discordgo.Ready{User: user}
+	for after := ""; ; {
+		page, err := c.gateway.UserGuilds(200, "", after, discordgo.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("could not enumerate guilds: %w", err)
+		}
+		for _, g := range page {
+			ready.Guilds = append(ready.Guilds, &discordgo.Guild{ID: g.ID, Name: g.Name})
+		}
+		if len(page) < 200 {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+
+	if err := c.gateway.State.OnInterface(c.gateway, ready); err != nil {
+		return fmt.Errorf("could not record the bot user: %w", err)
+	}
+	c.handleReady(c.s, ready)
+
+	return nil
+}
+
+// InteractionsHandler serves Discord's HTTP interactions endpoint, as an
+// alternative to receiving interactions over the gateway. Requests must be
+// signed with the application's public key within maxInteractionAge of being
+// received. The initial response to each interaction is written to the HTTP
+// response, and anything after that is sent through the API as usual.
+func (c *Commands) InteractionsHandler(publicKey ed25519.PublicKey) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxInteractionSize)
+		if !discordgo.VerifyInteraction(r, publicKey) {
+			http.Error(w, "invalid request signature", http.StatusUnauthorized)
+			return
+		}
+		if !recentlySigned(r, time.Now()) {
+			http.Error(w, "stale request", http.StatusUnauthorized)
+			return
+		}
+
+		i := &discordgo.Interaction{}
+		if err := json.NewDecoder(r.Body).Decode(i); err != nil {
+			http.Error(w, "invalid interaction", http.StatusBadRequest)
+			return
+		}
+
+		// Discord checks the endpoint with pings before enabling it
+		if i.Type == discordgo.InteractionPing {
+			writeInteractionResponse(w, &discordgo.InteractionResponse{Type: discordgo.InteractionResponsePong})
+			return
+		}
+
+		ctx, done, ok := c.begin()
+		if !ok {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		hs := &httpSession{
+			session:       c.s,
+			ctx:           ctx,
+			interactionID: i.ID,
+			replies:       make(chan httpReply),
+			gone:          make(chan struct{}),
+		}
+		defer close(hs.gone)
+
+		// The handler may carry on after the response has been written, such as
+		// to edit a deferred response
+		finished := make(chan struct{})
+		go func() {
+			defer done()
+			defer close(finished)
+
+			c.handler(ctx, hs, &discordgo.InteractionCreate{Interaction: i})
+		}()
+
+		select {
+		case reply := <-hs.replies:
+			reply.written <- writeInteractionResponse(w, reply.resp)
+		case <-finished:
+			log.Printf("Interaction %s was not answered", i.ID)
+			http.Error(w, "interaction was not answered", http.StatusInternalServerError)
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// recentlySigned reports whether the request's signed timestamp, in seconds
+// since the epoch, is within maxInteractionAge of now.
+func recentlySigned(r *http.Request, now time.Time) bool {
+	seconds, err := strconv.ParseInt(r.Header.Get("X-Signature-Timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	return age > -maxInteractionAge && age < maxInteractionAge
+}
+
+func writeInteractionResponse(w http.ResponseWriter, resp *discordgo.InteractionResponse) error {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	// Send all of it now, as the handler may go on to edit it through the API
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// httpSession answers an interaction in the body of the HTTP request that
+// delivered it, as Discord expects of the initial response to interactions
+// received over HTTP. Every other call goes to the underlying session.
+type httpSession struct {
+	session
+	// ctx is the context the interaction is handled with, which ends on
+	// shutdown or at the event's deadline
+	ctx           context.Context
+	interactionID string
+
+	mu       sync.Mutex
+	answered bool
+
+	// replies carries the initial response to the request handler, which
+	// reports back once it has been written. gone is closed when the request
+	// handler returns.
+	replies chan httpReply
+	gone    chan struct{}
+}
+
+type httpReply struct {
+	resp    *discordgo.InteractionResponse
+	written chan error
+}
+
+// respondsInline tells respond to defer responses with files, which can only
+// be uploaded through the API.
+func (s *httpSession) respondsInline() bool {
+	return true
+}
+
+func (s *httpSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	s.mu.Lock()
+	initial := interaction.ID == s.interactionID && !s.answered
+	if initial {
+		s.answered = true
+	}
+	s.mu.Unlock()
+
+	if !initial {
+		return s.session.InteractionRespond(interaction, resp, options...)
+	}
+	if resp.Data != nil && len(resp.Data.Files) > 0 {
+		return errInlineFiles
+	}
+
+	written := make(chan error, 1)
+	select {
+	case s.replies <- httpReply{resp: resp, written: written}:
+	case <-s.gone:
+		return errNoResponse
+	}
+	return <-written
+}
+
+func (s *httpSession) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (m *discordgo.Message, err error) {
+	err = s.retry(s.ctx, interaction, newresp.Files, func(files []*discordgo.File) (err error) {
+		edit := *newresp
+		edit.Files = files
+		m, err = s.session.InteractionResponseEdit(interaction, &edit, options...)
+		return err
+	})
+	return m, err
+}
+
+func (s *httpSession) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	return s.retry(s.ctx, interaction, nil, func([]*discordgo.File) error {
+		return s.session.InteractionResponseDelete(interaction, options...)
+	})
+}
+
+func (s *httpSession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (m *discordgo.Message, err error) {
+	err = s.retry(s.ctx, interaction, data.Files, func(files []*discordgo.File) (err error) {
+		params := *data
+		params.Files = files
+		m, err = s.session.FollowupMessageCreate(interaction, wait, &params, options...)
+		return err
+	})
+	return m, err
+}
+
+// retry calls fn until Discord knows of the interaction's initial response.
+// Discord may receive the response written to the HTTP request after the
+// handler's next call reaches the API, which then fails as if the interaction
+// didn't exist. Files are read up front so that each attempt gets a fresh copy.
+// Retries stop early once ctx is done.
+func (s *httpSession) retry(ctx context.Context, interaction *discordgo.Interaction, files []*discordgo.File, fn func(files []*discordgo.File) error) error {
+	if interaction.ID != s.interactionID {
+		return fn(files)
+	}
+
+	contents := make([][]byte, len(files))
+	for n, file := range files {
+		data, err := io.ReadAll(file.Reader)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", file.Name, err)
+		}
+		contents[n] = data
+	}
+
+	for attempt := 1; ; attempt++ {
+		copies := make([]*discordgo.File, len(files))
+		for n, file := range files {
+			copies[n] = &discordgo.File{Name: file.Name, ContentType: file.ContentType, Reader: bytes.NewReader(contents[n])}
+		}
+
+		err := fn(copies)
+		if attempt == unacknowledgedRetries || !isUnacknowledged(err) {
+			return err
+		}
+
+		timer := time.NewTimer(unacknowledgedRetryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// isUnacknowledged reports whether err is Discord failing to find an
+// interaction, or its webhook, before it has seen the initial response.
+func isUnacknowledged(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Message == nil {
+		return false
+	}
+
+	code := restErr.Message.Code
+	return code == discordgo.ErrCodeUnknownWebhook || code == discordgo.ErrCodeUnknownInteraction
+}
@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/discordtest"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+const pingFixture = `{"id":"900","application_id":"100","type":1,"token":"ping-token","version":1}`
+
+// signedRequest builds a request to the interactions endpoint signed the way
+// Discord signs them, at the given time.
+func signedRequest(t *testing.T, key ed25519.PrivateKey, body string, at time.Time) *http.Request {
+	t.Helper()
+
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	r := httptest.NewRequest(http.MethodPost, "/interactions", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Signature-Timestamp", timestamp)
+	r.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte(timestamp+body))))
+	return r
+}
+
+func TestCommands_InteractionsHandler(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFakeGuild()
+	admin := f.addMember("2", "admin", discordgo.PermissionAdministrator)
+	member := f.addMember("3", "member", 0)
+	general := f.addChannel("general")
+
+	encode := func(i *discordgo.InteractionCreate) string {
+		raw, err := json.Marshal(i.Interaction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+	show := f.command(member, general.ID, "rotator", subcommandOption("show"))
+	export := f.command(admin, general.ID, "state-export")
+
+	tests := []struct {
+		name        string
+		request     func() *http.Request
+		wantStatus  int
+		wantType    discordgo.InteractionResponseType
+		wantContent string
+		// wantReplies are the responses sent through the API, by interaction
+		wantReplies map[string][]string
+	}{
+		{
+			name: "unsigned",
+			request: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/interactions", strings.NewReader(pingFixture))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "signed by another key",
+			request:    func() *http.Request { return signedRequest(t, otherKey, pingFixture, time.Now()) },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "tampered",
+			request: func() *http.Request {
+				r := signedRequest(t, privateKey, pingFixture, time.Now())
+				r.Body = http.NoBody
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "stale",
+			request: func() *http.Request {
+				return signedRequest(t, privateKey, pingFixture, time.Now().Add(-time.Hour))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "from the future",
+			request: func() *http.Request {
+				return signedRequest(t, privateKey, pingFixture, time.Now().Add(time.Hour))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "not a post",
+			request:    func() *http.Request { return httptest.NewRequest(http.MethodGet, "/interactions", nil) },
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "ping",
+			request:    func() *http.Request { return signedRequest(t, privateKey, pingFixture, time.Now()) },
+			wantStatus: http.StatusOK,
+			wantType:   discordgo.InteractionResponsePong,
+		},
+		{
+			name:        "command",
+			request:     func() *http.Request { return signedRequest(t, privateKey, encode(show), time.Now()) },
+			wantStatus:  http.StatusOK,
+			wantType:    discordgo.InteractionResponseChannelMessageWithSource,
+			wantContent: ":warning: no users currently in rotation",
+			wantReplies: map[string][]string{},
+		},
+		{
+			name:        "files are sent as an edit",
+			request:     func() *http.Request { return signedRequest(t, privateKey, encode(export), time.Now()) },
+			wantStatus:  http.StatusOK,
+			wantType:    discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			wantReplies: map[string][]string{export.ID: {"edit:Exported 0 values"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.responses = map[string][]fakeResponse{}
+			c := newCommands(f, state.NewMemory())
+
+			w := httptest.NewRecorder()
+			c.InteractionsHandler(publicKey).ServeHTTP(w, tt.request())
+
+			// Wait for the handler to finish with anything sent after the response
+			if err := c.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				Type discordgo.InteractionResponseType `json:"type"`
+				Data struct {
+					Content string `json:"content"`
+				} `json:"data"`
+			}{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Type != tt.wantType || resp.Data.Content != tt.wantContent {
+				t.Errorf("response = %+v, want type %d with content %q", resp, tt.wantType, tt.wantContent)
+			}
+
+			if tt.wantReplies == nil {
+				return
+			}
+			got := map[string][]string{}
+			for id, replies := range f.responses {
+				for _, reply := range replies {
+					got[id] = append(got[id], reply.Kind+":"+reply.Content)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantReplies) {
+				t.Errorf("replies through the API = %v, want %v", got, tt.wantReplies)
+			}
+		})
+	}
+}
+
+func TestCommands_StartHTTP(t *testing.T) {
+	srv := discordtest.NewServer(t)
+	guildID := srv.NewID()
+	srv.AddGuild(&discordgo.Guild{ID: guildID, Name: "dumpster"})
+
+	s, err := srv.NewSession("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCommands(s, state.NewMemory())
+	if err := c.StartHTTP(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.State.User; got == nil || got.ID != srv.Bot.ID {
+		t.Errorf("bot user = %v, want %s", got, srv.Bot.ID)
+	}
+	if got, want := len(srv.Commands(guildID)), len(c.applicationCommands()); got != want {
+		t.Errorf("registered %d commands in the guild, want %d", got, want)
+	}
+}
+
+func TestCommands_InteractionsHandler_files(t *testing.T) {
+	srv := discordtest.NewServer(t)
+	guildID, general := srv.NewID(), srv.NewID()
+	srv.AddGuild(&discordgo.Guild{
+		ID:       guildID,
+		Name:     "dumpster",
+		Channels: []*discordgo.Channel{{ID: general, Name: "general", Type: discordgo.ChannelTypeGuildText}},
+	})
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := srv.NewSession("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCommands(s, state.NewMemory())
+	if err := c.StartHTTP(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	endpoint := httptest.NewServer(c.InteractionsHandler(publicKey))
+	defer endpoint.Close()
+
+	admin := &discordgo.Member{
+		User:        &discordgo.User{ID: srv.NewID(), Username: "admin"},
+		Permissions: discordgo.PermissionAdministrator,
+	}
+	i := &discordgo.Interaction{
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   guildID,
+		ChannelID: general,
+		Member:    admin,
+		Data:      discordgo.ApplicationCommandInteractionData{Name: "state-export"},
+	}
+	resp, err := srv.Deliver(context.Background(), endpoint.URL, privateKey, i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Errorf("initial response type = %d, want a deferred message", resp.Type)
+	}
+
+	// Wait for the handler to upload the export after the response
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	followups := srv.Followups(i.ID)
+	if len(followups) != 1 {
+		t.Fatalf("got %d messages after the response, want 1: %+v", len(followups), followups)
+	}
+	m := followups[0]
+	if m.Content != "Exported 0 values" || len(m.Attachments) != 1 || !strings.HasPrefix(m.Attachments[0].Filename, "lil-dumpster-") {
+		t.Errorf("export = %q with attachments %+v, want the export attached", m.Content, m.Attachments)
+	}
+}
+
+// unacknowledgedSession fails every deletion as Discord does before it has
+// seen an interaction's initial response.
+type unacknowledgedSession struct {
+	session
+	calls int
+}
+
+func (s *unacknowledgedSession) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	s.calls++
+	return &discordgo.RESTError{Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownWebhook}}
+}
+
+func Test_httpSession_retry(t *testing.T) {
+	tests := []struct {
+		name      string
+		cancelled bool
+		want      int
+	}{
+		{name: "until giving up", want: unacknowledgedRetries},
+		{name: "until the context is done", cancelled: true, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+
+			s := &unacknowledgedSession{session: newFakeGuild()}
+			hs := &httpSession{session: s, ctx: ctx, interactionID: "1"}
+			if err := hs.InteractionResponseDelete(&discordgo.Interaction{ID: "1"}); err == nil {
+				t.Error("httpSession.InteractionResponseDelete() error = nil, want the last failure")
+			}
+			if s.calls != tt.want {
+				t.Errorf("httpSession.InteractionResponseDelete() made %d attempts, want %d", s.calls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// interactionHandler handles an interaction of any type.
+type interactionHandler func(ctx context.Context, s session, i *discordgo.InteractionCreate)
+
+// middleware wraps an interactionHandler with behavior common to every
+// command, autocomplete and message component.
+type middleware func(next interactionHandler) interactionHandler
+
+// slowInteraction is how long a handler may run before it is logged as slow.
+// Discord fails interactions that aren't answered within three seconds.
+const slowInteraction = 2 * time.Second
+
+// errInternal is shown to users when a handler fails unexpectedly.
+var errInternal = errors.New("something went wrong handling that. Please try again later")
+
+// chain wraps h with the middleware, the first of which runs outermost.
+func chain(h interactionHandler, mw ...middleware) interactionHandler {
+	for n := len(mw) - 1; n >= 0; n-- {
+		h = mw[n](h)
+	}
+
+	return h
+}
+
+// withRecovery keeps a panicking handler from taking down the gateway, and
+// tells the user that their interaction failed.
+func withRecovery(next interactionHandler) interactionHandler {
+	return func(ctx context.Context, s session, i *discordgo.InteractionCreate) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			log.Printf("Recovered from panic handling %s: %v\n%s", interactionName(i), r, debug.Stack())
+
+			// The handler may already have responded, in which case this fails
+			switch i.Type {
+			case discordgo.InteractionApplicationCommandAutocomplete:
+				_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+					Data: &discordgo.InteractionResponseData{Choices: []*discordgo.ApplicationCommandOptionChoice{}},
+				}, discordgo.WithContext(ctx))
+			case discordgo.InteractionApplicationCommand, discordgo.InteractionMessageComponent:
+				commandError(ctx, s, i.Interaction, errInternal)
+			}
+		}()
+
+		next(ctx, s, i)
+	}
+}
+
+// withLogging logs each interaction once handled, along with how long it took.
+func withLogging(next interactionHandler) interactionHandler {
+	return func(ctx context.Context, s session, i *discordgo.InteractionCreate) {
+		start := time.Now()
+		next(ctx, s, i)
+		elapsed := time.Since(start)
+
+		userID := ""
+		if i.Member != nil && i.Member.User != nil {
+			userID = i.Member.User.ID
+		} else if i.User != nil {
+			userID = i.User.ID
+		}
+
+		log.Printf("interaction id=%s type=%s name=%q guild=%s channel=%s user=%s duration=%s",
+			i.ID, i.Type, interactionName(i), i.GuildID, i.ChannelID, userID, elapsed.Round(time.Millisecond))
+		if elapsed > slowInteraction {
+			log.Printf("Interaction %s for %s took %s, close to Discord's deadline", i.ID, interactionName(i), elapsed.Round(time.Millisecond))
+		}
+	}
+}
+
+// interactionName describes what an interaction invoked, such as
+// "/rotator add" or the CustomID of a button.
+func interactionName(i *discordgo.InteractionCreate) string {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand, discordgo.InteractionApplicationCommandAutocomplete:
+		data := i.ApplicationCommandData()
+		path, _ := subcommandPath(data.Options)
+		return strings.TrimSpace(fmt.Sprintf("/%s %s", data.Name, path))
+	case discordgo.InteractionMessageComponent:
+		return i.MessageComponentData().CustomID
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func Test_chain(t *testing.T) {
+	calls := []string{}
+	named := func(name string) middleware {
+		return func(next interactionHandler) interactionHandler {
+			return func(ctx context.Context, s session, i *discordgo.InteractionCreate) {
+				calls = append(calls, name+" before")
+				next(ctx, s, i)
+				calls = append(calls, name+" after")
+			}
+		}
+	}
+
+	h := chain(func(ctx context.Context, s session, i *discordgo.InteractionCreate) {
+		calls = append(calls, "handler")
+	}, named("outer"), named("inner"))
+	h(context.Background(), nil, &discordgo.InteractionCreate{})
+
+	want := []string{"outer before", "inner before", "handler", "inner after", "outer after"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("chain() calls = %v, want %v", calls, want)
+	}
+}
+
+func Test_withRecovery(t *testing.T) {
+	// Interactions of an unknown type aren't answered, so no session is needed
+	h := withRecovery(func(ctx context.Context, s session, i *discordgo.InteractionCreate) {
+		panic("oops")
+	})
+
+	h(context.Background(), nil, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Type: discordgo.InteractionPing}})
+}
+
+func Test_interactionName(t *testing.T) {
+	tests := []struct {
+		name string
+		i    *discordgo.Interaction
+		want string
+	}{
+		{
+			name: "command",
+			i: &discordgo.Interaction{Type: discordgo.InteractionApplicationCommand, Data: discordgo.ApplicationCommandInteractionData{
+				Name: "poll",
+			}},
+			want: "/poll",
+		},
+		{
+			name: "subcommand",
+			i: &discordgo.Interaction{Type: discordgo.InteractionApplicationCommandAutocomplete, Data: discordgo.ApplicationCommandInteractionData{
+				Name:    "rotator",
+				Options: commandOptions{{Name: "add", Type: discordgo.ApplicationCommandOptionSubCommand}},
+			}},
+			want: "/rotator add",
+		},
+		{
+			name: "component",
+			i: &discordgo.Interaction{Type: discordgo.InteractionMessageComponent, Data: discordgo.MessageComponentInteractionData{
+				CustomID: "poll:vote:1",
+			}},
+			want: "poll:vote:1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interactionName(&discordgo.InteractionCreate{Interaction: tt.i}); got != tt.want {
+				t.Errorf("interactionName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+// permissionsPrefix holds the roles each guild has allowed to run a gated
+// command, keyed by the command's path.
+const permissionsPrefix = "permissions/"
+
+// gate restricts a command or subcommand to members with a Discord permission
+// or one of the roles configured for it in their guild.
+type gate struct {
+	// Path names the command, such as "rotator add"
+	Path       string
+	Permission int64
+}
+
+// permissionNames are the display names of the permissions commands require.
+var permissionNames = map[int64]string{
+	discordgo.PermissionAdministrator:  "Administrator",
+	discordgo.PermissionManageChannels: "Manage Channels",
+	discordgo.PermissionManageRoles:    "Manage Roles",
+	discordgo.PermissionManageMessages: "Manage Messages",
+	discordgo.PermissionManageServer:   "Manage Server",
+}
+
+func permissionName(perm int64) string {
+	if name, ok := permissionNames[perm]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%x", perm)
+}
+
+// authorize checks that the member who triggered the interaction may run the
+// gated command.
+func authorize(ctx context.Context, store state.Backend, i *discordgo.Interaction, g gate) error {
+	if g.Permission == 0 {
+		return nil
+	}
+
+	denied := fmt.Errorf("you need the %s permission to use /%s", permissionName(g.Permission), g.Path)
+	if i.Member == nil {
+		return denied
+	}
+
+	if i.Member.Permissions&discordgo.PermissionAdministrator != 0 || i.Member.Permissions&g.Permission == g.Permission {
+		return nil
+	}
+
+	roles, err := commandRoles(ctx, guildStore(store, i.GuildID), g.Path)
+	if err != nil {
+		return fmt.Errorf("unable to check permissions: %w", err)
+	}
+
+	for _, role := range roles {
+		for _, memberRole := range i.Member.Roles {
+			if role == memberRole {
+				return nil
+			}
+		}
+	}
+
+	return denied
+}
+
+// commandRoles returns the roles a guild has allowed to run the gated command.
+func commandRoles(ctx context.Context, store state.Backend, path string) ([]string, error) {
+	roles := []string{}
+	err := store.Get(ctx, permissionsKey(path), &roles)
+	if errors.Is(err, state.ErrNotFound) {
+		err = nil
+	}
+
+	return roles, err
+}
+
+// updateCommandRoles atomically applies fn to the roles allowed to run the
+// gated command.
+func updateCommandRoles(ctx context.Context, store state.Backend, path string, fn func(roles []string) []string) error {
+	roles := []string{}
+	err := store.Update(ctx, permissionsKey(path), &roles, state.NoExpiry, func() error {
+		roles = fn(roles)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update permissions: %w", err)
+	}
+
+	return nil
+}
+
+func permissionsKey(path string) string {
+	return permissionsPrefix + strings.ReplaceAll(path, " ", "/")
+}
+
+// gates lists every gated command and subcommand, sorted by path.
+func (c *Commands) gates() []gate {
+	ret := []gate{}
+	for _, cmd := range c.commands {
+		if cmd.Permission != 0 {
+			ret = append(ret, gate{Path: cmd.Command.Name, Permission: cmd.Permission})
+		}
+
+		for path, sub := range cmd.Subcommands {
+			if sub.Permission != 0 {
+				ret = append(ret, gate{Path: cmd.Command.Name + " " + path, Permission: sub.Permission})
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret
+}
+
+// permissionsCommand lets administrators allow roles to run gated commands.
+// Unlike other commands it is built from the registered commands, so it is
+// added by NewCommands rather than through fnRegisterCommands.
+func (c *Commands) permissionsCommand() applicationCommand {
+	commandOption := &discordgo.ApplicationCommandOption{
+		Type:         discordgo.ApplicationCommandOptionString,
+		Name:         "command",
+		Description:  "Command to manage access to, such as \"rotator advance\"",
+		Required:     true,
+		Autocomplete: true,
+	}
+	roleOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionRole,
+		Name:        "role",
+		Description: "Role to manage access for",
+		Required:    true,
+	}
+
+	autocomplete := func(ctx context.Context, s session, i *discordgo.InteractionCreate, o *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+		ret := []*discordgo.ApplicationCommandOptionChoice{}
+		if o.Name != "command" {
+			return ret
+		}
+
+		for _, g := range c.gates() {
+			if strings.HasPrefix(g.Path, strings.ToLower(o.StringValue())) {
+				ret = append(ret, &discordgo.ApplicationCommandOptionChoice{Name: g.Path, Value: g.Path})
+			}
+		}
+
+		return ret
+	}
+
+	// change adds or removes the role for the command given in opts
+	change := func(allow bool) commandHandler {
+		return func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+			var path, roleID string
+			if opt := opts.get("command"); opt != nil {
+				path = strings.TrimPrefix(strings.TrimSpace(opt.StringValue()), "/")
+			}
+			if opt := opts.get("role"); opt != nil {
+				roleID = opt.Value.(string)
+			}
+
+			var found bool
+			for _, g := range c.gates() {
+				found = found || g.Path == path
+			}
+			if !found {
+				commandError(ctx, s, i.Interaction, fmt.Errorf("/%s is not a command that requires permission", path))
+				return
+			}
+
+			err := updateCommandRoles(ctx, guildStore(c.store, i.GuildID), path, func(roles []string) []string {
+				ret := []string{}
+				for _, role := range roles {
+					if role != roleID {
+						ret = append(ret, role)
+					}
+				}
+				if allow {
+					ret = append(ret, roleID)
+				}
+				return ret
+			})
+			if err != nil {
+				log.Println("Could not update command permissions:", err)
+				commandError(ctx, s, i.Interaction, err)
+				return
+			}
+
+			content := fmt.Sprintf("<@&%s> may now use /%s", roleID, path)
+			if !allow {
+				content = fmt.Sprintf("<@&%s> may no longer use /%s unless its members have the required permission", roleID, path)
+			}
+			err = respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Content:         content,
+					Flags:           1 << 6, // Ephemeral, private
+					AllowedMentions: &discordgo.MessageAllowedMentions{},
+				},
+			})
+			if err != nil {
+				log.Println("Could not respond to user message:", err)
+				commandError(ctx, s, i.Interaction, err)
+				return
+			}
+		}
+	}
+
+	list := func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+		lines := []string{}
+		for _, g := range c.gates() {
+			roles, err := commandRoles(ctx, guildStore(c.store, i.GuildID), g.Path)
+			if err != nil {
+				log.Println("Could not look up command permissions:", err)
+				commandError(ctx, s, i.Interaction, err)
+				return
+			}
+
+			line := fmt.Sprintf("* /%s: %s", g.Path, permissionName(g.Permission))
+			for _, role := range roles {
+				line += fmt.Sprintf(", <@&%s>", role)
+			}
+			lines = append(lines, line)
+		}
+
+		err := respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content:         "Commands may be used by members with the permission or roles listed:\n" + strings.Join(lines, "\n"),
+				Flags:           1 << 6, // Ephemeral, private
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			},
+		})
+		if err != nil {
+			log.Println("Could not respond to user message:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+	}
+
+	return applicationCommand{
+		Command: &discordgo.ApplicationCommand{
+			Name:        "permissions",
+			Description: "Manage which roles may use restricted commands",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "allow",
+					Description: "Allow a role to use a restricted command",
+					Options:     []*discordgo.ApplicationCommandOption{commandOption, roleOption},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "revoke",
+					Description: "Stop allowing a role to use a restricted command",
+					Options:     []*discordgo.ApplicationCommandOption{commandOption, roleOption},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "List the restricted commands and who may use them",
+				},
+			},
+		},
+		Permission: discordgo.PermissionAdministrator,
+		Subcommands: map[string]subcommand{
+			"allow":  {Handler: change(true), Autocomplete: autocomplete},
+			"revoke": {Handler: change(false), Autocomplete: autocomplete},
+			"list":   {Handler: list},
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+func Test_authorize(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewMemory()
+	err := updateCommandRoles(ctx, guildStore(store, "1"), "rotator advance", func(roles []string) []string {
+		return append(roles, "10")
+	})
+	if err != nil {
+		t.Fatalf("updateCommandRoles() error = %v", err)
+	}
+
+	advance := gate{Path: "rotator advance", Permission: discordgo.PermissionManageChannels}
+
+	tests := []struct {
+		name    string
+		gate    gate
+		guild   string
+		member  *discordgo.Member
+		wantErr bool
+	}{
+		{
+			name:  "ungated",
+			gate:  gate{Path: "rotator show"},
+			guild: "1",
+		},
+		{
+			name:    "no member",
+			gate:    advance,
+			wantErr: true,
+		},
+		{
+			name:   "has permission",
+			gate:   advance,
+			guild:  "1",
+			member: &discordgo.Member{Permissions: discordgo.PermissionManageChannels | discordgo.PermissionSendMessages},
+		},
+		{
+			name:   "administrator",
+			gate:   advance,
+			guild:  "1",
+			member: &discordgo.Member{Permissions: discordgo.PermissionAdministrator},
+		},
+		{
+			name:   "allowed role",
+			gate:   advance,
+			guild:  "1",
+			member: &discordgo.Member{Roles: []string{"9", "10"}},
+		},
+		{
+			name:    "role allowed in another guild",
+			gate:    advance,
+			guild:   "2",
+			member:  &discordgo.Member{Roles: []string{"10"}},
+			wantErr: true,
+		},
+		{
+			name:    "denied",
+			gate:    advance,
+			guild:   "1",
+			member:  &discordgo.Member{Permissions: discordgo.PermissionSendMessages, Roles: []string{"9"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &discordgo.Interaction{GuildID: tt.guild, Member: tt.member}
+			if err := authorize(ctx, store, i, tt.gate); (err != nil) != tt.wantErr {
+				t.Errorf("authorize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
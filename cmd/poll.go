@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
@@ -26,6 +27,10 @@ type pollChoice struct {
 
 var pollMutex sync.Mutex
 
+// maxPollChoices is the number of buttons polls posted with the legacy
+// CustomIDs could have.
+const maxPollChoices = 20
+
 func init() {
 	fnRegisterCommands = append(fnRegisterCommands, func(store state.Backend) []applicationCommand {
 		return []applicationCommand{
@@ -54,104 +59,29 @@ func init() {
 						},
 					},
 				},
-				MessageComponents: func() map[string]func(*discordgo.Session, *discordgo.InteractionCreate) {
-					ret := map[string]func(*discordgo.Session, *discordgo.InteractionCreate){}
-
-					// first the tiebreaker button
-					ret["pollButtonTiebreaker"] = func(s *discordgo.Session, interaction *discordgo.InteractionCreate) {
-						log.Println("Button clicked: ", interaction.Message.ID, interaction.Member.User.Username)
-						poll := parsePoll(interaction.Message.Content)
-
-						ties, ok := poll.hasTie()
-						if !ok {
-							return
-						}
-						chosen := rand.Intn(len(ties))
-						log.Printf("chose %d as a tiebreaker", chosen)
-
-						poll.choices[chosen].count++
-						poll.choices[chosen].mentions = append(poll.choices[chosen].mentions, s.State.User.Mention())
-
-						// Build the buttons
-						buttons := poll.buttons()
-
-						s.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
-							Type: discordgo.InteractionResponseUpdateMessage,
-							Data: &discordgo.InteractionResponseData{
-								Content: poll.serialize(),
-								Flags:   interaction.Message.Flags,
-								Components: []discordgo.MessageComponent{
-									discordgo.ActionsRow{Components: buttons},
-								},
-							},
-						})
+				MessageComponents: func() map[string]componentHandler {
+					ret := map[string]componentHandler{
+						"poll:tiebreaker": pollTiebreaker,
+						"poll:vote":       pollVote,
 					}
 
-					// next the voting buttons
-					for i := 0; i < 20; i++ {
-						choiceN := i
-						customID := fmt.Sprintf("pollButton%d", i)
-						ret[customID] = func(s *discordgo.Session, interaction *discordgo.InteractionCreate) {
-							pollMutex.Lock()
-							defer pollMutex.Unlock()
-
-							log.Println("Button clicked: ", interaction.Message.ID, interaction.Member.User.Username)
-							poll := parsePoll(interaction.Message.Content)
-
-							// Build the new user list
-							var alreadyVoted bool
-							newUsers := []string{}
-							for _, user := range poll.choices[choiceN].mentions {
-								// Skip the bot which might have a tiebreaker vote
-								if user == s.State.User.Mention() {
-									continue
-								}
-
-								// If the user already voted, they're un-voting
-								if user == interaction.Member.Mention() {
-									alreadyVoted = true
-									continue
-								}
-
-								newUsers = append(newUsers, user)
-							}
-
-							// If the user is voting for the first time, add them
-							if !alreadyVoted {
-								newUsers = append(newUsers, interaction.Member.Mention())
-							}
-
-							poll.choices[choiceN].count = len(newUsers)
-							poll.choices[choiceN].mentions = newUsers
-
-							// Log the new poll string
-							log.Println("New poll: ", poll)
-
-							// Build the buttons
-							buttons := poll.buttons()
-
-							// And update the string on the server
-							s.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
-								Type: discordgo.InteractionResponseUpdateMessage,
-								Data: &discordgo.InteractionResponseData{
-									Content: poll.serialize(),
-									Flags:   interaction.Message.Flags,
-									Components: []discordgo.MessageComponent{
-										discordgo.ActionsRow{Components: buttons},
-									},
-								},
-							})
+					// Polls posted before votes were routed by prefix carry these IDs
+					ret["pollButtonTiebreaker"] = pollTiebreaker
+					for i := 0; i < maxPollChoices; i++ {
+						choiceN := strconv.Itoa(i)
+						ret[fmt.Sprintf("pollButton%d", i)] = func(ctx context.Context, s session, interaction *discordgo.InteractionCreate, _ []string) {
+							pollVote(ctx, s, interaction, []string{choiceN})
 						}
 					}
 
 					return ret
 				}(),
-				Handler: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+				Handler: func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
 					// Build the poll
 					poll := poll{prompt: "Poll:"}
 					var choicesString string
 					var flags discordgo.MessageFlags
-					for _, opt := range i.ApplicationCommandData().Options {
+					for _, opt := range opts {
 						switch opt.Name {
 						case "choices":
 							choicesString = opt.StringValue()
@@ -181,7 +111,7 @@ func init() {
 					buttons := poll.buttons()
 
 					// Send the poll!
-					err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					err := respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
 						Type: discordgo.InteractionResponseChannelMessageWithSource,
 						Data: &discordgo.InteractionResponseData{
 							Content: poll.serialize(),
@@ -193,7 +123,7 @@ func init() {
 					})
 					if err != nil {
 						log.Println("Could not respond to user message:", err)
-						commandError(s, i.Interaction, err)
+						commandError(ctx, s, i.Interaction, err)
 						return
 					}
 				},
@@ -202,18 +132,109 @@ func init() {
 	})
 }
 
+func pollTiebreaker(ctx context.Context, s session, interaction *discordgo.InteractionCreate, _ []string) {
+	log.Println("Button clicked: ", interaction.Message.ID, interaction.Member.User.Username)
+	poll := parsePoll(interaction.Message.Content)
+
+	chosen, ok := poll.tiebreaker()
+	if !ok {
+		return
+	}
+	log.Printf("chose %d as a tiebreaker", chosen)
+
+	poll.choices[chosen].count++
+	poll.choices[chosen].mentions = append(poll.choices[chosen].mentions, s.BotUser().Mention())
+
+	// Build the buttons
+	buttons := poll.buttons()
+
+	respond(ctx, s, interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content: poll.serialize(),
+			Flags:   interaction.Message.Flags,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: buttons},
+			},
+		},
+	})
+}
+
+// pollVote toggles the user's vote for the choice index given in args.
+func pollVote(ctx context.Context, s session, interaction *discordgo.InteractionCreate, args []string) {
+	pollMutex.Lock()
+	defer pollMutex.Unlock()
+
+	log.Println("Button clicked: ", interaction.Message.ID, interaction.Member.User.Username)
+	poll := parsePoll(interaction.Message.Content)
+
+	if len(args) != 1 {
+		log.Println("Malformed poll vote: ", args)
+		return
+	}
+	choiceN, err := strconv.Atoi(args[0])
+	if err != nil || choiceN < 0 || choiceN >= len(poll.choices) {
+		log.Println("Vote for unknown poll choice: ", args[0])
+		return
+	}
+
+	// Build the new user list
+	var alreadyVoted bool
+	newUsers := []string{}
+	for _, user := range poll.choices[choiceN].mentions {
+		// Skip the bot which might have a tiebreaker vote
+		if user == s.BotUser().Mention() {
+			continue
+		}
+
+		// If the user already voted, they're un-voting
+		if user == interaction.Member.Mention() {
+			alreadyVoted = true
+			continue
+		}
+
+		newUsers = append(newUsers, user)
+	}
+
+	// If the user is voting for the first time, add them
+	if !alreadyVoted {
+		newUsers = append(newUsers, interaction.Member.Mention())
+	}
+
+	poll.choices[choiceN].count = len(newUsers)
+	poll.choices[choiceN].mentions = newUsers
+
+	// Log the new poll string
+	log.Println("New poll: ", poll)
+
+	// Build the buttons
+	buttons := poll.buttons()
+
+	// And update the string on the server
+	respond(ctx, s, interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content: poll.serialize(),
+			Flags:   interaction.Message.Flags,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: buttons},
+			},
+		},
+	})
+}
+
 func (p *poll) buttons() []discordgo.MessageComponent {
 	buttons := []discordgo.MessageComponent{}
 	for i := range p.choices {
 		buttons = append(buttons, discordgo.Button{
-			CustomID: fmt.Sprintf("pollButton%d", i),
+			CustomID: customID("poll:vote", strconv.Itoa(i)),
 			Label:    fmt.Sprintf("%d", i+1),
 		})
 	}
 
 	if _, tie := p.hasTie(); tie {
 		buttons = append(buttons, discordgo.Button{
-			CustomID: "pollButtonTiebreaker",
+			CustomID: customID("poll:tiebreaker"),
 			Label:    "Tiebreaker!",
 		})
 	}
@@ -305,3 +326,14 @@ func (p *poll) hasTie() ([]int, bool) {
 
 	return maxIndexes, len(maxIndexes) > 1
 }
+
+// tiebreaker picks the index of one of the tied choices at random, if there is
+// a tie.
+func (p *poll) tiebreaker() (int, bool) {
+	ties, ok := p.hasTie()
+	if !ok {
+		return 0, false
+	}
+
+	return ties[rand.Intn(len(ties))], true
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
 )
 
 func Test_parsePoll(t *testing.T) {
@@ -199,6 +200,48 @@ func Test_poll_hasTie(t *testing.T) {
 	}
 }
 
+func Test_poll_tiebreaker(t *testing.T) {
+	tests := []struct {
+		name    string
+		choices []pollChoice
+		want    map[int]bool
+	}{
+		{
+			name: "no tie",
+			choices: []pollChoice{
+				{choice: "foo", count: 2},
+				{choice: "bar", count: 1},
+			},
+		},
+		{
+			name: "tie after an unpopular choice",
+			choices: []pollChoice{
+				{choice: "foo", count: 0},
+				{choice: "bar", count: 1},
+				{choice: "baz", count: 3},
+				{choice: "qux", count: 3},
+			},
+			want: map[int]bool{2: true, 3: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &poll{choices: tt.choices}
+
+			// The choice is random, so make sure it's never one that isn't tied
+			for n := 0; n < 100; n++ {
+				got, ok := p.tiebreaker()
+				if ok != (tt.want != nil) {
+					t.Fatalf("poll.tiebreaker() ok = %v, want %v", ok, tt.want != nil)
+				}
+				if ok && !tt.want[got] {
+					t.Fatalf("poll.tiebreaker() = %d, want one of %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
 func Test_poll_buttons(t *testing.T) {
 	type fields struct {
 		prompt  string
@@ -223,9 +266,9 @@ func Test_poll_buttons(t *testing.T) {
 				},
 			},
 			want: []discordgo.MessageComponent{
-				discordgo.Button{CustomID: "pollButton0", Label: "1"},
-				discordgo.Button{CustomID: "pollButton1", Label: "2"},
-				discordgo.Button{CustomID: "pollButton2", Label: "3"},
+				discordgo.Button{CustomID: "poll:vote:0", Label: "1"},
+				discordgo.Button{CustomID: "poll:vote:1", Label: "2"},
+				discordgo.Button{CustomID: "poll:vote:2", Label: "3"},
 			},
 		},
 		{
@@ -238,10 +281,10 @@ func Test_poll_buttons(t *testing.T) {
 				},
 			},
 			want: []discordgo.MessageComponent{
-				discordgo.Button{CustomID: "pollButton0", Label: "1"},
-				discordgo.Button{CustomID: "pollButton1", Label: "2"},
-				discordgo.Button{CustomID: "pollButton2", Label: "3"},
-				discordgo.Button{CustomID: "pollButtonTiebreaker", Label: "Tiebreaker!"},
+				discordgo.Button{CustomID: "poll:vote:0", Label: "1"},
+				discordgo.Button{CustomID: "poll:vote:1", Label: "2"},
+				discordgo.Button{CustomID: "poll:vote:2", Label: "3"},
+				discordgo.Button{CustomID: "poll:tiebreaker", Label: "Tiebreaker!"},
 			},
 		},
 	}
@@ -257,3 +300,49 @@ func Test_poll_buttons(t *testing.T) {
 		})
 	}
 }
+
+func Test_pollCommands(t *testing.T) {
+	f := newFakeGuild()
+	alice := f.addMember("1", "alice", 0)
+	bob := f.addMember("2", "bob", 0)
+	channel := f.addChannel("general")
+	c := newCommands(f, state.NewMemory())
+
+	i := f.command(alice, channel.ID, "poll", stringOption("choices", "Tacos, Pizza"), stringOption("prompt", "Lunch?"))
+	c.handleCommand(f, i)
+	replies := f.replies(i.ID)
+	if len(replies) != 1 {
+		t.Fatalf("poll got %d replies, want 1", len(replies))
+	}
+	message := &discordgo.Message{ID: "10", ChannelID: channel.ID, Content: replies[0].Content}
+
+	// click presses a button on the poll message, which is updated in place
+	click := func(member *discordgo.Member, customID string) {
+		i := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			ID:        f.newID(),
+			Type:      discordgo.InteractionMessageComponent,
+			GuildID:   f.id,
+			ChannelID: channel.ID,
+			Member:    member,
+			Message:   message,
+			Data:      discordgo.MessageComponentInteractionData{CustomID: customID, ComponentType: discordgo.ButtonComponent},
+		}}
+		c.handleCommand(f, i)
+
+		replies := f.replies(i.ID)
+		if len(replies) != 1 || replies[0].Type != discordgo.InteractionResponseUpdateMessage {
+			t.Fatalf("click %s got replies %+v, want a message update", customID, replies)
+		}
+		message.Content = replies[0].Content
+	}
+
+	click(alice, "poll:vote:0")
+	click(bob, "poll:vote:1")
+	click(bob, "pollButton0")
+	click(bob, "poll:vote:1")
+
+	want := "Lunch?\n1. Tacos (2, <@!1>, <@!2>)\n2. Pizza (0)\n"
+	if message.Content != want {
+		t.Errorf("poll = %q, want %q", message.Content, want)
+	}
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+const (
+	// purgePrefix holds the deadline after which each departed guild's state
+	// is deleted.
+	purgePrefix = "purge/"
+
+	// purgeInterval is how often the leader checks for guilds due for purging.
+	purgeInterval = 10 * time.Minute
+)
+
+// handleGuildDelete schedules a guild's state for deletion once the bot has
+// been removed from it. The grace period allows for the bot being re-invited.
+func (c *Commands) handleGuildDelete(s session, event *discordgo.GuildDelete) {
+	// Unavailable guilds are suffering an outage rather than removing the bot
+	if event.Unavailable {
+		return
+	}
+	c.forgetGuild(event.ID)
+
+	ctx, done, ok := c.begin()
+	if !ok {
+		return
+	}
+	defer done()
+
+	deadline := time.Now().Add(c.purgeGrace)
+	if err := schedulePurge(ctx, c.store, event.ID, deadline); err != nil {
+		log.Printf("Failed to schedule purge of guild %q: %s", event.ID, err)
+		return
+	}
+
+	log.Printf("Removed from guild %q. Its state will be deleted after %s", event.ID, deadline.Format(time.RFC3339))
+}
+
+// handleGuildCreate sets up guilds the bot has joined since starting, and
+// cancels any pending purge for a guild the bot has rejoined.
+func (c *Commands) handleGuildCreate(s session, event *discordgo.GuildCreate) {
+	if !event.Unavailable {
+		c.setupGuild(s, event.ID)
+	}
+
+	ctx, done, ok := c.begin()
+	if !ok {
+		return
+	}
+	defer done()
+
+	if cancelled, err := cancelPurge(ctx, c.store, event.ID); err != nil {
+		log.Printf("Failed to cancel purge of guild %q: %s", event.ID, err)
+	} else if cancelled {
+		log.Printf("Rejoined guild %q. Its state will be kept", event.ID)
+	}
+}
+
+// handleChannelDelete removes the state belonging to a deleted channel.
+func (c *Commands) handleChannelDelete(s session, event *discordgo.ChannelDelete) {
+	ctx, done, ok := c.begin()
+	if !ok {
+		return
+	}
+	defer done()
+
+	if err := deleteChannelState(ctx, c.store, event.GuildID, event.ID); err != nil {
+		log.Printf("Failed to delete state for channel %q: %s", event.ID, err)
+	}
+}
+
+// runPurges periodically deletes the state of guilds whose grace period has
+// passed, until ctx is done.
+func (c *Commands) runPurges(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := sweepPurges(ctx, c.store, time.Now()); err != nil && ctx.Err() == nil {
+			log.Println("Failed to purge departed guilds:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func schedulePurge(ctx context.Context, store state.Backend, guildID string, deadline time.Time) error {
+	// Keep the earliest deadline if the guild is removed more than once
+	existing := time.Time{}
+	return store.Update(ctx, purgePrefix+guildID, &existing, state.NoExpiry, func() error {
+		if existing.IsZero() || deadline.Before(existing) {
+			existing = deadline
+		}
+		return nil
+	})
+}
+
+func cancelPurge(ctx context.Context, store state.Backend, guildID string) (bool, error) {
+	deadline := time.Time{}
+	if err := store.Get(ctx, purgePrefix+guildID, &deadline); errors.Is(err, state.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, store.Delete(ctx, purgePrefix+guildID)
+}
+
+// sweepPurges deletes the state of every guild whose deadline is before now.
+func sweepPurges(ctx context.Context, store state.Backend, now time.Time) error {
+	keys, err := store.List(ctx, purgePrefix)
+	if err != nil {
+		return fmt.Errorf("unable to list scheduled purges: %w", err)
+	}
+
+	for _, key := range keys {
+		deadline := time.Time{}
+		if err := store.Get(ctx, key, &deadline); errors.Is(err, state.ErrNotFound) {
+			// Cancelled while we were working
+			continue
+		} else if err != nil {
+			return fmt.Errorf("unable to read %q: %w", key, err)
+		} else if now.Before(deadline) {
+			continue
+		}
+
+		// The guild may have been rejoined since its deadline was read, so
+		// confirm the purge is still due right before deleting anything
+		if due, err := purgeDue(ctx, store, key, now); err != nil {
+			return fmt.Errorf("unable to confirm %q: %w", key, err)
+		} else if !due {
+			continue
+		}
+
+		guildID := strings.TrimPrefix(key, purgePrefix)
+		deleted, err := deletePrefix(ctx, store, guildPrefix(guildID))
+		if err != nil {
+			return fmt.Errorf("unable to purge guild %q: %w", guildID, err)
+		}
+
+		// Purging is best effort: the guild may still be rejoined while its
+		// keys are being deleted, taking anything it writes meanwhile with
+		// them. Check again so that the lost race is at least reported, and a
+		// purge scheduled since isn't cleared along with this one.
+		if due, err := purgeDue(ctx, store, key, now); err != nil {
+			return fmt.Errorf("unable to confirm %q: %w", key, err)
+		} else if !due {
+			log.Printf("Guild %q was rejoined while its state was being purged. State it saved in the meantime may have been lost", guildID)
+			continue
+		}
+
+		if err := store.Delete(ctx, key); err != nil {
+			return fmt.Errorf("unable to remove %q: %w", key, err)
+		}
+
+		log.Printf("Purged %d values belonging to departed guild %q", deleted, guildID)
+	}
+
+	return nil
+}
+
+// errPurgeNotDue aborts the update in purgeDue without writing.
+var errPurgeNotDue = errors.New("purge is not due")
+
+// purgeDue atomically checks that a scheduled purge has neither been cancelled
+// nor postponed.
+func purgeDue(ctx context.Context, store state.Backend, key string, now time.Time) (bool, error) {
+	deadline := time.Time{}
+	err := store.Update(ctx, key, &deadline, state.NoExpiry, func() error {
+		// A cancelled purge reads as the zero time, and mustn't be recreated
+		if deadline.IsZero() || now.Before(deadline) {
+			return errPurgeNotDue
+		}
+		return nil
+	})
+
+	if errors.Is(err, errPurgeNotDue) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func deleteChannelState(ctx context.Context, store state.Backend, guildID, channelID string) error {
+	_, err := deletePrefix(ctx, guildStore(store, guildID), newRotator(channelID, nil).prefix)
+	return err
+}
+
+// deletePrefix removes every key beginning with prefix.
+func deletePrefix(ctx context.Context, store state.Backend, prefix string) (int, error) {
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list keys: %w", err)
+	}
+
+	for i, key := range keys {
+		if err := store.Delete(ctx, key); err != nil {
+			return i, fmt.Errorf("unable to delete %q: %w", key, err)
+		}
+	}
+
+	return len(keys), nil
+}
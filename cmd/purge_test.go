@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+func Test_sweepPurges(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	store := state.NewMemory()
+	store.Set(ctx, "guild/1/rotator/10/rotation", rotation{}, state.NoExpiry)
+	store.Set(ctx, "guild/2/rotator/20/rotation", rotation{}, state.NoExpiry)
+	store.Set(ctx, "guild/3/rotator/30/rotation", rotation{}, state.NoExpiry)
+	store.Set(ctx, "guild/10/rotator/40/rotation", rotation{}, state.NoExpiry)
+
+	schedulePurge(ctx, store, "1", now.Add(-time.Minute))
+	schedulePurge(ctx, store, "2", now.Add(time.Hour))
+	schedulePurge(ctx, store, "3", now.Add(-time.Minute))
+	if cancelled, err := cancelPurge(ctx, store, "3"); !cancelled || err != nil {
+		t.Errorf("cancelPurge() = %v, %v, want true", cancelled, err)
+	}
+
+	if err := sweepPurges(ctx, store, now); err != nil {
+		t.Fatalf("sweepPurges() error = %v", err)
+	}
+
+	keys, _ := store.List(ctx, "")
+	want := []string{
+		"guild/10/rotator/40/rotation",
+		"guild/2/rotator/20/rotation",
+		"guild/3/rotator/30/rotation",
+		"purge/2",
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("sweepPurges() keys = %v, want %v", keys, want)
+	}
+}
+
+func Test_schedulePurge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Round(0)
+	store := state.NewMemory()
+
+	schedulePurge(ctx, store, "1", now)
+	schedulePurge(ctx, store, "1", now.Add(time.Hour))
+
+	got := time.Time{}
+	store.Get(ctx, "purge/1", &got)
+	if !got.Equal(now) {
+		t.Errorf("schedulePurge() deadline = %v, want earliest %v", got, now)
+	}
+
+	if cancelled, _ := cancelPurge(ctx, store, "2"); cancelled {
+		t.Error("cancelPurge() = true for unscheduled guild")
+	}
+}
+
+func Test_deleteChannelState(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewMemory()
+	store.Set(ctx, "guild/1/rotator/10/rotation", rotation{}, state.NoExpiry)
+	store.Set(ctx, "guild/1/rotator/100/rotation", rotation{}, state.NoExpiry)
+	store.Set(ctx, "guild/2/rotator/10/rotation", rotation{}, state.NoExpiry)
+
+	if err := deleteChannelState(ctx, store, "1", "10"); err != nil {
+		t.Fatalf("deleteChannelState() error = %v", err)
+	}
+
+	keys, _ := store.List(ctx, "")
+	want := []string{"guild/1/rotator/100/rotation", "guild/2/rotator/10/rotation"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("deleteChannelState() keys = %v, want %v", keys, want)
+	}
+}
+
+// rejoiningStore cancels a guild's purge as soon as its deadline has been
+// read, as if the bot were invited back at that moment.
+type rejoiningStore struct {
+	state.Backend
+	guildID string
+}
+
+func (s *rejoiningStore) Get(ctx context.Context, key string, value interface{}) error {
+	err := s.Backend.Get(ctx, key, value)
+	if key == purgePrefix+s.guildID {
+		cancelPurge(ctx, s.Backend, s.guildID)
+	}
+	return err
+}
+
+func Test_sweepPurges_rejoined(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	store := state.NewMemory()
+	store.Set(ctx, "guild/1/rotator/10/rotation", rotation{}, state.NoExpiry)
+	schedulePurge(ctx, store, "1", now.Add(-time.Minute))
+
+	if err := sweepPurges(ctx, &rejoiningStore{Backend: store, guildID: "1"}, now); err != nil {
+		t.Fatalf("sweepPurges() error = %v", err)
+	}
+
+	keys, _ := store.List(ctx, "")
+	if want := []string{"guild/1/rotator/10/rotation"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("sweepPurges() keys = %v, want %v", keys, want)
+	}
+}
+
+// leavingStore cancels a guild's purge while its state is being deleted, and
+// schedules a new one, as if the bot were invited back and then removed again.
+type leavingStore struct {
+	state.Backend
+	guildID  string
+	deadline time.Time
+}
+
+func (s *leavingStore) Delete(ctx context.Context, key string) error {
+	if strings.HasPrefix(key, guildPrefix(s.guildID)) {
+		cancelPurge(ctx, s.Backend, s.guildID)
+		schedulePurge(ctx, s.Backend, s.guildID, s.deadline)
+	}
+	return s.Backend.Delete(ctx, key)
+}
+
+func Test_sweepPurges_rejoinedWhileDeleting(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	store := state.NewMemory()
+	store.Set(ctx, "guild/1/rotator/10/rotation", rotation{}, state.NoExpiry)
+	schedulePurge(ctx, store, "1", now.Add(-time.Minute))
+
+	later := now.Add(time.Hour)
+	if err := sweepPurges(ctx, &leavingStore{Backend: store, guildID: "1", deadline: later}, now); err != nil {
+		t.Fatalf("sweepPurges() error = %v", err)
+	}
+
+	// The purge scheduled by the second removal is kept
+	got := time.Time{}
+	if err := store.Get(ctx, purgePrefix+"1", &got); err != nil || !got.Equal(later) {
+		t.Errorf("purge deadline = %v, %v, want %v", got, err, later)
+	}
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// commandChanges summarizes the difference between the registered and the
+// desired application commands, by name.
+type commandChanges struct {
+	Created   []string
+	Updated   []string
+	Deleted   []string
+	Unchanged int
+}
+
+// registerCommands reconciles the application commands registered in a guild,
+// or globally when guildID is empty, with the desired set. Discord is only
+// contacted again if something changed, in which case every command is
+// overwritten at once so that stale ones are deleted.
+func registerCommands(ctx context.Context, s session, guildID string, desired []*discordgo.ApplicationCommand) error {
+	scope := "globally"
+	if guildID != "" {
+		scope = fmt.Sprintf("in guild %q", guildID)
+	}
+
+	registered, err := s.ApplicationCommands(s.BotUser().ID, guildID, discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("unable to list application commands %s: %w", scope, err)
+	}
+
+	changes := diffCommands(registered, desired)
+	if !changes.changed() {
+		if len(desired) > 0 {
+			log.Printf("Application commands %s are up to date (%d commands)", scope, changes.Unchanged)
+		}
+		return nil
+	}
+
+	if _, err := s.ApplicationCommandBulkOverwrite(s.BotUser().ID, guildID, desired, discordgo.WithContext(ctx)); err != nil {
+		return fmt.Errorf("unable to overwrite application commands %s: %w", scope, err)
+	}
+
+	log.Printf("Reconciled application commands %s: %s", scope, changes)
+	return nil
+}
+
+// diffCommands compares the registered commands against the desired ones.
+func diffCommands(registered, desired []*discordgo.ApplicationCommand) commandChanges {
+	existing := map[string]*discordgo.ApplicationCommand{}
+	for _, cmd := range registered {
+		existing[cmd.Name] = cmd
+	}
+
+	ret := commandChanges{}
+	for _, cmd := range desired {
+		current, ok := existing[cmd.Name]
+		switch {
+		case !ok:
+			ret.Created = append(ret.Created, cmd.Name)
+		case commandSpec(current) != commandSpec(cmd):
+			ret.Updated = append(ret.Updated, cmd.Name)
+		default:
+			ret.Unchanged++
+		}
+		delete(existing, cmd.Name)
+	}
+
+	for name := range existing {
+		ret.Deleted = append(ret.Deleted, name)
+	}
+	sort.Strings(ret.Deleted)
+
+	return ret
+}
+
+func (c commandChanges) changed() bool {
+	return len(c.Created)+len(c.Updated)+len(c.Deleted) > 0
+}
+
+func (c commandChanges) String() string {
+	return fmt.Sprintf("created [%s], updated [%s], deleted [%s], %d unchanged",
+		strings.Join(c.Created, ", "),
+		strings.Join(c.Updated, ", "),
+		strings.Join(c.Deleted, ", "),
+		c.Unchanged,
+	)
+}
+
+// commandSpec renders the user-facing definition of a command, ignoring the
+// metadata Discord assigns and the defaults it fills in, for comparison.
+func commandSpec(cmd *discordgo.ApplicationCommand) string {
+	spec := *cmd
+	spec.ID, spec.ApplicationID, spec.GuildID, spec.Version = "", "", "", ""
+	spec.DefaultPermission = nil
+
+	if spec.Type == 0 {
+		spec.Type = discordgo.ChatApplicationCommand
+	}
+	if spec.DMPermission == nil {
+		allowed := true
+		spec.DMPermission = &allowed
+	}
+	if spec.NSFW != nil && !*spec.NSFW {
+		spec.NSFW = nil
+	}
+	spec.Options = normalizeOptions(spec.Options)
+
+	data, _ := json.Marshal(spec)
+	return string(data)
+}
+
+// normalizeOptions makes empty and missing lists compare as equal.
+func normalizeOptions(opts []*discordgo.ApplicationCommandOption) []*discordgo.ApplicationCommandOption {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	ret := make([]*discordgo.ApplicationCommandOption, 0, len(opts))
+	for _, opt := range opts {
+		normalized := *opt
+		if len(normalized.ChannelTypes) == 0 {
+			normalized.ChannelTypes = nil
+		}
+		if len(normalized.Choices) == 0 {
+			normalized.Choices = nil
+		}
+		normalized.Options = normalizeOptions(normalized.Options)
+		ret = append(ret, &normalized)
+	}
+
+	return ret
+}
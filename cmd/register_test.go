@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func Test_diffCommands(t *testing.T) {
+	admin := int64(discordgo.PermissionAdministrator)
+	allowed := true
+
+	desired := []*discordgo.ApplicationCommand{
+		{
+			Name:        "poll",
+			Description: "Submit a poll to the channel",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "choices", Description: "Choices", Required: true},
+			},
+		},
+		{Name: "state-export", Description: "Download a backup", DefaultMemberPermissions: &admin},
+		{Name: "rotator", Description: "Manage the channel rotation"},
+	}
+
+	tests := []struct {
+		name       string
+		registered []*discordgo.ApplicationCommand
+		want       commandChanges
+	}{
+		{
+			name: "nothing registered",
+			want: commandChanges{Created: []string{"poll", "state-export", "rotator"}},
+		},
+		{
+			name: "defaults filled in by discord",
+			registered: []*discordgo.ApplicationCommand{
+				{
+					ID:            "1",
+					ApplicationID: "2",
+					Version:       "3",
+					Type:          discordgo.ChatApplicationCommand,
+					Name:          "poll",
+					Description:   "Submit a poll to the channel",
+					DMPermission:  &allowed,
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionString, Name: "choices", Description: "Choices", Required: true, Choices: []*discordgo.ApplicationCommandOptionChoice{}},
+					},
+				},
+				{ID: "4", Name: "state-export", Description: "Download a backup", DefaultMemberPermissions: &admin},
+				{ID: "5", Name: "rotator", Description: "Manage the channel rotation", Options: []*discordgo.ApplicationCommandOption{}},
+			},
+			want: commandChanges{Unchanged: 3},
+		},
+		{
+			name: "changed and stale",
+			registered: []*discordgo.ApplicationCommand{
+				{Name: "poll", Description: "Submit a poll to the channel"},
+				{Name: "state-export", Description: "Download a backup"},
+				{Name: "rotator", Description: "Manage the channel rotation"},
+				{Name: "rotator-advance", Description: "Advance the channel rotation"},
+				{Name: "rotator-add", Description: "Add a user to the channel rotation"},
+			},
+			want: commandChanges{
+				Updated:   []string{"poll", "state-export"},
+				Deleted:   []string{"rotator-add", "rotator-advance"},
+				Unchanged: 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffCommands(tt.registered, desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffCommands() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// deferAfter is how long a handler may take to respond before the response is
+// deferred. Discord fails interactions that aren't answered within three
+// seconds, and the deferral itself needs time to arrive.
+const deferAfter = 1500 * time.Millisecond
+
+type responderSession interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+}
+
+// inlineResponder is implemented by sessions that send the initial response to
+// an interaction somewhere other than the API, which can't carry files. Such
+// responses are deferred by respond so that the files are attached to the edit
+// that completes them.
+type inlineResponder interface {
+	respondsInline() bool
+}
+
+// pendingResponse tracks how an interaction that may be deferred has been
+// answered so far.
+type pendingResponse struct {
+	mu sync.Mutex
+	// deferred is the type of the deferred response, if one was sent
+	deferred discordgo.InteractionResponseType
+	// answered is set once the handler's own response has been sent
+	answered bool
+}
+
+// pendingResponses holds a *pendingResponse for each interaction being handled
+// by withDeferral, keyed by interaction ID.
+var pendingResponses sync.Map
+
+// withDeferral defers the response to commands and message components whose
+// handler hasn't responded within the threshold, so that Discord doesn't fail
+// them. Handlers must respond through respond for their response to be
+// delivered after a deferral.
+func withDeferral(threshold time.Duration) middleware {
+	return func(next interactionHandler) interactionHandler {
+		return func(ctx context.Context, s session, i *discordgo.InteractionCreate) {
+			var deferred discordgo.InteractionResponseType
+			switch i.Type {
+			case discordgo.InteractionApplicationCommand:
+				deferred = discordgo.InteractionResponseDeferredChannelMessageWithSource
+			case discordgo.InteractionMessageComponent:
+				deferred = discordgo.InteractionResponseDeferredMessageUpdate
+			default:
+				next(ctx, s, i)
+				return
+			}
+
+			pending := &pendingResponse{}
+			pendingResponses.Store(i.ID, pending)
+			defer pendingResponses.Delete(i.ID)
+
+			timer := time.AfterFunc(threshold, func() {
+				pending.deferResponse(ctx, s, i.Interaction, deferred)
+			})
+			defer timer.Stop()
+
+			next(ctx, s, i)
+		}
+	}
+}
+
+// deferResponse tells Discord that a response is coming, unless the handler
+// has responded in the meantime. Deferred messages are ephemeral, as they may
+// turn out to be errors.
+func (p *pendingResponse) deferResponse(ctx context.Context, s responderSession, i *discordgo.Interaction, deferred discordgo.InteractionResponseType) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.answered {
+		return
+	}
+
+	if err := p.sendDeferral(ctx, s, i, deferred); err != nil {
+		log.Printf("Could not defer response to interaction %s: %s", i.ID, err)
+	}
+}
+
+// sendDeferral sends a deferred response of the given type. It must be called
+// with the lock held.
+func (p *pendingResponse) sendDeferral(ctx context.Context, s responderSession, i *discordgo.Interaction, deferred discordgo.InteractionResponseType) error {
+	resp := &discordgo.InteractionResponse{Type: deferred}
+	if deferred == discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		resp.Data = &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral}
+	}
+
+	if err := s.InteractionRespond(i, resp, discordgo.WithContext(ctx)); err != nil {
+		return err
+	}
+	p.deferred = deferred
+	return nil
+}
+
+// respond answers an interaction, editing or following up on the deferred
+// response if the handler took too long.
+func respond(ctx context.Context, s responderSession, i *discordgo.Interaction, resp *discordgo.InteractionResponse) error {
+	value, ok := pendingResponses.Load(i.ID)
+	if !ok {
+		return s.InteractionRespond(i, resp, discordgo.WithContext(ctx))
+	}
+
+	p := value.(*pendingResponse)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Only the first response may complete a deferral
+	answered := p.answered
+	p.answered = true
+
+	// Files that can't go in the initial response follow in an edit
+	if p.deferred == 0 && !answered && hasFiles(resp) {
+		if inline, ok := s.(inlineResponder); ok && inline.respondsInline() {
+			deferred := discordgo.InteractionResponseDeferredChannelMessageWithSource
+			if resp.Type == discordgo.InteractionResponseUpdateMessage {
+				deferred = discordgo.InteractionResponseDeferredMessageUpdate
+			}
+			if err := p.sendDeferral(ctx, s, i, deferred); err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.deferred == 0 {
+		return s.InteractionRespond(i, resp, discordgo.WithContext(ctx))
+	}
+
+	data := resp.Data
+	if data == nil {
+		data = &discordgo.InteractionResponseData{}
+	}
+
+	// Edit the deferred message in place, as long as it has the same
+	// visibility as the response
+	replaces := !answered && (resp.Type == discordgo.InteractionResponseUpdateMessage ||
+		p.deferred == discordgo.InteractionResponseDeferredChannelMessageWithSource &&
+			data.Flags&discordgo.MessageFlagsEphemeral != 0)
+	if replaces {
+		_, err := s.InteractionResponseEdit(i, &discordgo.WebhookEdit{
+			Content:         &data.Content,
+			Components:      &data.Components,
+			Embeds:          &data.Embeds,
+			Files:           data.Files,
+			AllowedMentions: data.AllowedMentions,
+		}, discordgo.WithContext(ctx))
+		return err
+	}
+
+	// A public response can't replace an ephemeral "thinking" message
+	if !answered && p.deferred == discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		if err := s.InteractionResponseDelete(i, discordgo.WithContext(ctx)); err != nil {
+			log.Printf("Could not delete deferred response to interaction %s: %s", i.ID, err)
+		}
+	}
+
+	_, err := s.FollowupMessageCreate(i, true, &discordgo.WebhookParams{
+		Content:         data.Content,
+		Components:      data.Components,
+		Embeds:          data.Embeds,
+		Files:           data.Files,
+		AllowedMentions: data.AllowedMentions,
+		Flags:           data.Flags,
+	}, discordgo.WithContext(ctx))
+	return err
+}
+
+func hasFiles(resp *discordgo.InteractionResponse) bool {
+	return resp.Data != nil && len(resp.Data.Files) > 0
+}
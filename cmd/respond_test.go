@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// recordingResponder records the calls made to answer an interaction.
+type recordingResponder struct {
+	calls []string
+}
+
+func (r *recordingResponder) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	r.calls = append(r.calls, "respond")
+	return nil
+}
+
+func (r *recordingResponder) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	r.calls = append(r.calls, "edit")
+	return &discordgo.Message{}, nil
+}
+
+func (r *recordingResponder) InteractionResponseDelete(interaction *discordgo.Interaction, options ...discordgo.RequestOption) error {
+	r.calls = append(r.calls, "delete")
+	return nil
+}
+
+func (r *recordingResponder) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	r.calls = append(r.calls, "followup")
+	return &discordgo.Message{}, nil
+}
+
+func Test_respond(t *testing.T) {
+	ctx := context.Background()
+	ephemeral := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: "private", Flags: discordgo.MessageFlagsEphemeral},
+	}
+	public := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: "public"},
+	}
+	update := &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{Content: "updated"},
+	}
+
+	tests := []struct {
+		name      string
+		pending   bool
+		deferred  discordgo.InteractionResponseType
+		responses []*discordgo.InteractionResponse
+		want      []string
+	}{
+		{
+			name:      "not tracked",
+			responses: []*discordgo.InteractionResponse{public},
+			want:      []string{"respond"},
+		},
+		{
+			name:      "answered in time",
+			pending:   true,
+			deferred:  discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			responses: []*discordgo.InteractionResponse{public},
+			want:      []string{"respond"},
+		},
+		{
+			name:      "deferred ephemeral message",
+			pending:   true,
+			deferred:  discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			responses: []*discordgo.InteractionResponse{nil, ephemeral},
+			want:      []string{"respond", "edit"},
+		},
+		{
+			name:      "deferred public message",
+			pending:   true,
+			deferred:  discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			responses: []*discordgo.InteractionResponse{nil, public},
+			want:      []string{"respond", "delete", "followup"},
+		},
+		{
+			name:      "deferred message update",
+			pending:   true,
+			deferred:  discordgo.InteractionResponseDeferredMessageUpdate,
+			responses: []*discordgo.InteractionResponse{nil, update},
+			want:      []string{"respond", "edit"},
+		},
+		{
+			name:      "error after deferred update",
+			pending:   true,
+			deferred:  discordgo.InteractionResponseDeferredMessageUpdate,
+			responses: []*discordgo.InteractionResponse{nil, ephemeral},
+			want:      []string{"respond", "followup"},
+		},
+		{
+			name:      "second response after deferral",
+			pending:   true,
+			deferred:  discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			responses: []*discordgo.InteractionResponse{nil, ephemeral, ephemeral},
+			want:      []string{"respond", "edit", "followup"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &recordingResponder{}
+			i := &discordgo.Interaction{ID: tt.name}
+
+			var pending *pendingResponse
+			if tt.pending {
+				pending = &pendingResponse{}
+				pendingResponses.Store(i.ID, pending)
+				defer pendingResponses.Delete(i.ID)
+			}
+
+			// A nil response stands for the handler running past the threshold
+			for _, resp := range tt.responses {
+				if resp == nil {
+					pending.deferResponse(ctx, s, i, tt.deferred)
+					continue
+				}
+				if err := respond(ctx, s, i, resp); err != nil {
+					t.Fatalf("respond() error = %v", err)
+				}
+			}
+			if pending != nil {
+				pending.deferResponse(ctx, s, i, tt.deferred)
+			}
+
+			if !reflect.DeepEqual(s.calls, tt.want) {
+				t.Errorf("respond() calls = %v, want %v", s.calls, tt.want)
+			}
+		})
+	}
+}
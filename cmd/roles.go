@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -29,12 +30,12 @@ func init() {
 						},
 					},
 				},
-				Autocomplete: func(s *discordgo.Session, i *discordgo.InteractionCreate, o *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+				Autocomplete: func(ctx context.Context, s session, i *discordgo.InteractionCreate, o *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
 					ret := []*discordgo.ApplicationCommandOptionChoice{}
 
 					switch o.Name {
 					case "role-name":
-						roles, err := s.GuildRoles(i.GuildID)
+						roles, err := s.GuildRoles(i.GuildID, discordgo.WithContext(ctx))
 						if err != nil {
 							return ret
 						}
@@ -67,16 +68,19 @@ func init() {
 
 					return ret
 				},
-				Handler: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-					roleName := i.ApplicationCommandData().Options[0].StringValue()
-					err := addRoleToUser(s, i.Interaction, roleName)
+				Handler: func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+					var roleName string
+					if opt := opts.get("role-name"); opt != nil {
+						roleName = opt.StringValue()
+					}
+					err := addRoleToUser(ctx, s, i.Interaction, roleName)
 					if err != nil {
 						log.Println("Could not handle role addition:", err)
-						commandError(s, i.Interaction, err)
+						commandError(ctx, s, i.Interaction, err)
 						return
 					}
 
-					err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					err = respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
 						Type: discordgo.InteractionResponseChannelMessageWithSource,
 						Data: &discordgo.InteractionResponseData{
 							Content: fmt.Sprintf("The %q role has been added to your user", roleName),
@@ -85,7 +89,7 @@ func init() {
 					})
 					if err != nil {
 						log.Println("Could not respond to user message:", err)
-						commandError(s, i.Interaction, err)
+						commandError(ctx, s, i.Interaction, err)
 						return
 					}
 				},
@@ -104,12 +108,17 @@ func init() {
 						},
 					},
 				},
-				Autocomplete: func(s *discordgo.Session, i *discordgo.InteractionCreate, o *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
+				Autocomplete: func(ctx context.Context, s session, i *discordgo.InteractionCreate, o *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice {
 					ret := []*discordgo.ApplicationCommandOptionChoice{}
 
 					switch o.Name {
 					case "role-name":
-						roles, err := s.GuildRoles(i.GuildID)
+						// Direct messages have no member or roles to remove
+						if i.Member == nil {
+							return ret
+						}
+
+						roles, err := s.GuildRoles(i.GuildID, discordgo.WithContext(ctx))
 						if err != nil {
 							return ret
 						}
@@ -143,16 +152,19 @@ func init() {
 
 					return ret
 				},
-				Handler: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-					roleName := i.ApplicationCommandData().Options[0].StringValue()
-					err := removeRoleFromUser(s, i.Interaction, roleName)
+				Handler: func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+					var roleName string
+					if opt := opts.get("role-name"); opt != nil {
+						roleName = opt.StringValue()
+					}
+					err := removeRoleFromUser(ctx, s, i.Interaction, roleName)
 					if err != nil {
 						log.Println("Could not handle role removal:", err)
-						commandError(s, i.Interaction, err)
+						commandError(ctx, s, i.Interaction, err)
 						return
 					}
 
-					err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					err = respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
 						Type: discordgo.InteractionResponseChannelMessageWithSource,
 						Data: &discordgo.InteractionResponseData{
 							Content: fmt.Sprintf("The %q role has been removed from your user", roleName),
@@ -161,7 +173,7 @@ func init() {
 					})
 					if err != nil {
 						log.Println("Could not respond to user message:", err)
-						commandError(s, i.Interaction, err)
+						commandError(ctx, s, i.Interaction, err)
 						return
 					}
 				},
@@ -170,15 +182,15 @@ func init() {
 	})
 }
 
-func manageRoles(s *discordgo.Session, guild *discordgo.Guild) error {
+func manageRoles(ctx context.Context, s session, guild *discordgo.Guild) error {
 	const rolesChannelName = "roles"
 
-	rolesChannel, err := findChannel(s, guild.ID, rolesChannelName)
+	rolesChannel, err := findChannel(ctx, s, guild.ID, rolesChannelName)
 	if err != nil {
 		return fmt.Errorf("could not find %s channel: %w", rolesChannelName, err)
 	}
 
-	messages, err := s.ChannelMessagesPinned(rolesChannel.ID)
+	messages, err := s.ChannelMessagesPinned(rolesChannel.ID, discordgo.WithContext(ctx))
 	if err != nil {
 		return fmt.Errorf("could not find pinned channel messages: %w", err)
 	}
@@ -186,7 +198,7 @@ func manageRoles(s *discordgo.Session, guild *discordgo.Guild) error {
 	// Filter to only messages by the bot
 	var activeMessage *discordgo.Message
 	for _, m := range messages {
-		if m.Author.ID != s.State.User.ID {
+		if m.Author.ID != s.BotUser().ID {
 			continue
 		}
 
@@ -196,19 +208,19 @@ func manageRoles(s *discordgo.Session, guild *discordgo.Guild) error {
 	messageText := buildRolesMessage()
 	if activeMessage == nil {
 		// No messages found! Post and pin an initial message
-		activeMessage, err = s.ChannelMessageSend(rolesChannel.ID, messageText)
+		activeMessage, err = s.ChannelMessageSend(rolesChannel.ID, messageText, discordgo.WithContext(ctx))
 		if err != nil {
 			return fmt.Errorf("could not post a new channel message: %w", err)
 		}
 
-		if err := s.ChannelMessagePin(rolesChannel.ID, activeMessage.ID); err != nil {
+		if err := s.ChannelMessagePin(rolesChannel.ID, activeMessage.ID, discordgo.WithContext(ctx)); err != nil {
 			return fmt.Errorf("could not pin the new channel message: %w", err)
 		}
 	}
 
 	if activeMessage.Content != messageText {
 		// Update the message text to match expected
-		_, err = s.ChannelMessageEdit(rolesChannel.ID, activeMessage.ID, messageText)
+		_, err = s.ChannelMessageEdit(rolesChannel.ID, activeMessage.ID, messageText, discordgo.WithContext(ctx))
 		if err != nil {
 			return fmt.Errorf("could not update the message text: %w", err)
 		}
@@ -217,8 +229,8 @@ func manageRoles(s *discordgo.Session, guild *discordgo.Guild) error {
 	return nil
 }
 
-func addRoleToUser(s *discordgo.Session, i *discordgo.Interaction, roleName string) error {
-	role, err := findRoleForName(s, i.GuildID, roleName)
+func addRoleToUser(ctx context.Context, s session, i *discordgo.Interaction, roleName string) error {
+	role, err := findRoleForName(ctx, s, i.GuildID, roleName)
 	if err != nil {
 		return fmt.Errorf("could not find role: %w", err)
 	}
@@ -228,15 +240,15 @@ func addRoleToUser(s *discordgo.Session, i *discordgo.Interaction, roleName stri
 	}
 
 	fmt.Printf("Adding role %s to user %v in guild %s\n", role.Name, i.Member.User.Username, i.GuildID)
-	err = s.GuildMemberRoleAdd(i.GuildID, i.Member.User.ID, role.ID)
+	err = s.GuildMemberRoleAdd(i.GuildID, i.Member.User.ID, role.ID, discordgo.WithContext(ctx))
 	if err != nil && strings.Contains(err.Error(), "50013") {
 		return errUnauthorizedRole
 	}
 	return err
 }
 
-func removeRoleFromUser(s *discordgo.Session, i *discordgo.Interaction, roleName string) error {
-	role, err := findRoleForName(s, i.GuildID, roleName)
+func removeRoleFromUser(ctx context.Context, s session, i *discordgo.Interaction, roleName string) error {
+	role, err := findRoleForName(ctx, s, i.GuildID, roleName)
 	if err != nil {
 		return fmt.Errorf("could not find role: %w", err)
 	}
@@ -246,15 +258,15 @@ func removeRoleFromUser(s *discordgo.Session, i *discordgo.Interaction, roleName
 	}
 
 	fmt.Printf("Removing role %s from user %s in guild %s\n", role.Name, i.Member.User.Username, i.GuildID)
-	err = s.GuildMemberRoleRemove(i.GuildID, i.Member.User.ID, role.ID)
+	err = s.GuildMemberRoleRemove(i.GuildID, i.Member.User.ID, role.ID, discordgo.WithContext(ctx))
 	if err != nil && strings.Contains(err.Error(), "50013") {
 		return errUnauthorizedRole
 	}
 	return err
 }
 
-func findRoleForName(s *discordgo.Session, guildID string, name string) (*discordgo.Role, error) {
-	roles, err := s.GuildRoles(guildID)
+func findRoleForName(ctx context.Context, s session, guildID string, name string) (*discordgo.Role, error) {
+	roles, err := s.GuildRoles(guildID, discordgo.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("could not enumerate guild roles: %w", err)
 	}
@@ -268,8 +280,8 @@ func findRoleForName(s *discordgo.Session, guildID string, name string) (*discor
 	return nil, fmt.Errorf("could not find a guild role for the configured role '%s'", name)
 }
 
-func findChannel(s *discordgo.Session, guildID, channelName string) (*discordgo.Channel, error) {
-	channels, err := s.GuildChannels(guildID)
+func findChannel(ctx context.Context, s session, guildID, channelName string) (*discordgo.Channel, error) {
+	channels, err := s.GuildChannels(guildID, discordgo.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("could not enumerate guild channels: %w", err)
 	}
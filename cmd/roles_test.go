@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+func Test_roleCommands(t *testing.T) {
+	f := newFakeGuild()
+	member := f.addMember("1", "member", 0)
+	gamers := f.addRole("Gamers")
+	mods := f.addRole("Mods")
+	f.unassignable[mods.ID] = true
+	channel := f.addChannel("general")
+	c := newCommands(f, state.NewMemory())
+
+	steps := []struct {
+		name      string
+		command   string
+		role      string
+		want      string
+		wantRoles []string
+	}{
+		{
+			name:      "add",
+			command:   "role-add",
+			role:      "gamers",
+			want:      `The "gamers" role has been added to your user`,
+			wantRoles: []string{gamers.ID},
+		},
+		{
+			name:      "add unknown",
+			command:   "role-add",
+			role:      "Nobody",
+			want:      ":warning: could not find role: could not find a guild role for the configured role 'Nobody'",
+			wantRoles: []string{gamers.ID},
+		},
+		{
+			name:      "add unassignable",
+			command:   "role-add",
+			role:      "Mods",
+			want:      ":warning: not authorized to manage this role",
+			wantRoles: []string{gamers.ID},
+		},
+		{
+			name:      "remove",
+			command:   "role-remove",
+			role:      "Gamers",
+			want:      `The "Gamers" role has been removed from your user`,
+			wantRoles: []string{},
+		},
+	}
+	for _, step := range steps {
+		i := f.command(member, channel.ID, step.command, stringOption("role-name", step.role))
+		c.handleCommand(f, i)
+
+		if replies := f.replies(i.ID); len(replies) != 1 || replies[0].Content != step.want {
+			t.Errorf("%s: replies = %+v, want %q", step.name, replies, step.want)
+		}
+		if got := f.memberRoles(member.User.ID); !reflect.DeepEqual(got, step.wantRoles) {
+			t.Errorf("%s: roles = %v, want %v", step.name, got, step.wantRoles)
+		}
+	}
+}
+
+func Test_roleAutocomplete(t *testing.T) {
+	f := newFakeGuild()
+	member := f.addMember("1", "member", 0)
+	f.addRole("@everyone")
+	gamers := f.addRole("Gamers")
+	f.addRole("Gardeners")
+	f.addRole("Mods")
+	member.Roles = []string{gamers.ID}
+	c := newCommands(f, state.NewMemory())
+
+	tests := []struct {
+		name    string
+		command string
+		member  *discordgo.Member
+		want    []string
+	}{
+		{name: "add skips held roles", command: "role-add", member: member, want: []string{"Gardeners"}},
+		{name: "remove offers held roles", command: "role-remove", member: member, want: []string{"Gamers"}},
+		{name: "remove in a direct message", command: "role-remove", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := stringOption("role-name", "ga")
+			opt.Focused = true
+			i := f.command(tt.member, "", tt.command, opt)
+			i.Type = discordgo.InteractionApplicationCommandAutocomplete
+			c.handleCommand(f, i)
+
+			replies := f.replies(i.ID)
+			if len(replies) != 1 {
+				t.Fatalf("got %d replies, want 1", len(replies))
+			}
+			got := []string{}
+			for _, choice := range replies[0].Choices {
+				got = append(got, choice.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("choices = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_manageRoles(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGuild()
+	roles := f.addChannel("roles")
+	guild := &discordgo.Guild{ID: f.id}
+
+	if err := manageRoles(ctx, f, guild); err != nil {
+		t.Fatalf("manageRoles() error = %v", err)
+	}
+	if got := f.pinned(roles.ID); !reflect.DeepEqual(got, []string{buildRolesMessage()}) {
+		t.Fatalf("manageRoles() pinned = %q, want the roles message", got)
+	}
+
+	// An outdated message is updated rather than posting another
+	pins, _ := f.ChannelMessagesPinned(roles.ID)
+	f.ChannelMessageEdit(roles.ID, pins[0].ID, "outdated")
+	if err := manageRoles(ctx, f, guild); err != nil {
+		t.Fatalf("manageRoles() error = %v", err)
+	}
+	if got := f.pinned(roles.ID); !reflect.DeepEqual(got, []string{buildRolesMessage()}) {
+		t.Errorf("manageRoles() pinned = %q, want the roles message", got)
+	}
+}
+
+func Test_manageRoles_noChannel(t *testing.T) {
+	f := newFakeGuild()
+	f.addChannel("general")
+
+	if err := manageRoles(context.Background(), f, &discordgo.Guild{ID: f.id}); err == nil {
+		t.Error("manageRoles() error = nil, want an error for a guild without a roles channel")
+	}
+}
@@ -17,6 +17,11 @@ type (
 		channel string
 		store   state.Backend
 		prefix  string
+
+		// legacy is the unscoped store that held the channel's rotation before
+		// state was namespaced by guild, if it may still be there
+		legacy  state.Backend
+		guildID string
 	}
 
 	rotationUser struct {
@@ -40,199 +45,233 @@ func init() {
 			{
 				Command: &discordgo.ApplicationCommand{
 					Name:        "rotator",
-					Description: "Display the current user in the channel rotation",
+					Description: "Manage the channel rotation",
 					Options: []*discordgo.ApplicationCommandOption{
 						{
-							Type:        discordgo.ApplicationCommandOptionBoolean,
-							Name:        "announce",
-							Description: "Post the response publicly for all to see",
+							Type:        discordgo.ApplicationCommandOptionSubCommand,
+							Name:        "show",
+							Description: "Display the current user in the channel rotation",
+							Options: []*discordgo.ApplicationCommandOption{
+								{
+									Type:        discordgo.ApplicationCommandOptionBoolean,
+									Name:        "announce",
+									Description: "Post the response publicly for all to see",
+								},
+							},
 						},
-					},
-				},
-				Handler: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-					var announce bool
-					if len(i.ApplicationCommandData().Options) > 0 {
-						announce = i.ApplicationCommandData().Options[0].BoolValue()
-					}
-
-					rot := newRotator(i.ChannelID, store)
-
-					currentUser, err := rot.Current(context.TODO())
-					if err != nil {
-						log.Println("Could not look up current user:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-
-					list, err := rot.ListFormatted(context.TODO(), s)
-					if err != nil {
-						log.Println("Could not render current list:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-
-					var flags discordgo.MessageFlags
-					if !announce {
-						flags = 1 << 6 // Ephemeral, private
-					}
-					err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-						Type: discordgo.InteractionResponseChannelMessageWithSource,
-						Data: &discordgo.InteractionResponseData{
-							Content: fmt.Sprintf("%s\n\n%s is the current user as of <t:%d:R>", list, currentUser.resolve(s).Mention(), currentUser.LastAssigned.Unix()),
-							Flags:   flags,
-						},
-					})
-					if err != nil {
-						log.Println("Could not respond to user message:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-				},
-			},
-			{
-				Command: &discordgo.ApplicationCommand{
-					Name:        "rotator-add",
-					Description: "Add a user to the channel rotation",
-					Options: []*discordgo.ApplicationCommandOption{
 						{
-							Type:        discordgo.ApplicationCommandOptionUser,
-							Name:        "username",
-							Description: "Name of the user to be added",
-							Required:    true,
+							Type:        discordgo.ApplicationCommandOptionSubCommand,
+							Name:        "add",
+							Description: "Add a user to the channel rotation",
+							Options: []*discordgo.ApplicationCommandOption{
+								{
+									Type:        discordgo.ApplicationCommandOptionUser,
+									Name:        "username",
+									Description: "Name of the user to be added",
+									Required:    true,
+								},
+							},
 						},
-					},
-				},
-				Handler: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-					user := i.ApplicationCommandData().Options[0].UserValue(s)
-
-					if user == nil {
-						log.Println("A user must be provided")
-						commandError(s, i.Interaction, errors.New("a user must be provided"))
-						return
-					}
-
-					rot := newRotator(i.ChannelID, store)
-					if err := rot.AddUser(context.TODO(), *user); err != nil {
-						log.Println("Could not add user to rotation:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-
-					list, err := rot.ListFormatted(context.TODO(), s)
-					if err != nil {
-						log.Println("Could not render current list:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-
-					err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-						Type: discordgo.InteractionResponseChannelMessageWithSource,
-						Data: &discordgo.InteractionResponseData{
-							Content: fmt.Sprintf("%s\n\n%s has been added to the rotation", list, user.Mention()),
-							Flags:   1 << 6, // Ephemeral, private
-						},
-					})
-					if err != nil {
-						log.Println("Could not respond to user message:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-				},
-			},
-			{
-				Command: &discordgo.ApplicationCommand{
-					Name:        "rotator-remove",
-					Description: "Remove a person from the channel rotation",
-					Options: []*discordgo.ApplicationCommandOption{
 						{
-							Type:        discordgo.ApplicationCommandOptionUser,
-							Name:        "username",
-							Description: "Name of the user to be added",
-							Required:    true,
-						},
-					},
-				},
-				Handler: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-					user := i.ApplicationCommandData().Options[0].UserValue(s)
-
-					rot := newRotator(i.ChannelID, store)
-					if err := rot.RemoveUser(context.TODO(), user.ID); err != nil {
-						log.Println("Could not remove user from rotation:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-
-					list, err := rot.ListFormatted(context.TODO(), s)
-					if err != nil {
-						log.Println("Could not render current list:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-
-					err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-						Type: discordgo.InteractionResponseChannelMessageWithSource,
-						Data: &discordgo.InteractionResponseData{
-							Content: fmt.Sprintf("%s\n\n%s has been removed from the rotation", list, user.Mention()),
-							Flags:   1 << 6, // Ephemeral, private
+							Type:        discordgo.ApplicationCommandOptionSubCommand,
+							Name:        "remove",
+							Description: "Remove a person from the channel rotation",
+							Options: []*discordgo.ApplicationCommandOption{
+								{
+									Type:        discordgo.ApplicationCommandOptionUser,
+									Name:        "username",
+									Description: "Name of the user to be removed",
+									Required:    true,
+								},
+							},
 						},
-					})
-					if err != nil {
-						log.Println("Could not respond to user message:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-				},
-			},
-			{
-				Command: &discordgo.ApplicationCommand{
-					Name:        "rotator-advance",
-					Description: "Advance the channel rotation to the next user",
-					Options: []*discordgo.ApplicationCommandOption{
 						{
-							Type:        discordgo.ApplicationCommandOptionBoolean,
-							Name:        "reverse",
-							Description: "Advance to the prior user in the rotation",
+							Type:        discordgo.ApplicationCommandOptionSubCommand,
+							Name:        "advance",
+							Description: "Advance the channel rotation to the next user",
+							Options: []*discordgo.ApplicationCommandOption{
+								{
+									Type:        discordgo.ApplicationCommandOptionBoolean,
+									Name:        "reverse",
+									Description: "Advance to the prior user in the rotation",
+								},
+							},
 						},
 					},
 				},
-				Handler: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-					var reverse bool
-					if len(i.ApplicationCommandData().Options) > 0 {
-						reverse = i.ApplicationCommandData().Options[0].BoolValue()
-					}
-
-					rot := newRotator(i.ChannelID, store)
-					user, err := rot.Advance(context.TODO(), reverse)
-					if err != nil {
-						log.Println("Could not advance rotation:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-
-					list, err := rot.ListFormatted(context.TODO(), s)
-					if err != nil {
-						log.Println("Could not render current list:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
-
-					err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-						Type: discordgo.InteractionResponseChannelMessageWithSource,
-						Data: &discordgo.InteractionResponseData{
-							Content: fmt.Sprintf("%s\n\n%s is now assigned in the rotation!", list, user.resolve(s).Mention()),
-						},
-					})
-					if err != nil {
-						log.Println("Could not respond to user message:", err)
-						commandError(s, i.Interaction, err)
-						return
-					}
+				Subcommands: map[string]subcommand{
+					"show":    {Handler: rotatorShow(store)},
+					"add":     {Handler: rotatorAdd(store), Permission: discordgo.PermissionManageChannels},
+					"remove":  {Handler: rotatorRemove(store), Permission: discordgo.PermissionManageChannels},
+					"advance": {Handler: rotatorAdvance(store), Permission: discordgo.PermissionManageChannels},
 				},
 			},
 		}
 	})
 }
 
+func rotatorShow(store state.Backend) commandHandler {
+	return func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+		var announce bool
+		if opt := opts.get("announce"); opt != nil {
+			announce = opt.BoolValue()
+		}
+
+		rot := newGuildRotator(i.ChannelID, store, i.GuildID)
+
+		currentUser, err := rot.Current(ctx)
+		if err != nil {
+			log.Println("Could not look up current user:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+
+		list, err := rot.ListFormatted(ctx, s)
+		if err != nil {
+			log.Println("Could not render current list:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+
+		var flags discordgo.MessageFlags
+		if !announce {
+			flags = 1 << 6 // Ephemeral, private
+		}
+		err = respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("%s\n\n%s is the current user as of <t:%d:R>", list, currentUser.resolve(ctx, s).Mention(), currentUser.LastAssigned.Unix()),
+				Flags:   flags,
+			},
+		})
+		if err != nil {
+			log.Println("Could not respond to user message:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+	}
+}
+
+func rotatorAdd(store state.Backend) commandHandler {
+	return func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+		var user *discordgo.User
+		if opt := opts.get("username"); opt != nil {
+			user = opt.UserValue(nil)
+		}
+
+		if user == nil {
+			log.Println("A user must be provided")
+			commandError(ctx, s, i.Interaction, errors.New("a user must be provided"))
+			return
+		}
+
+		rot := newGuildRotator(i.ChannelID, store, i.GuildID)
+		if err := rot.AddUser(ctx, *user); err != nil {
+			log.Println("Could not add user to rotation:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+
+		list, err := rot.ListFormatted(ctx, s)
+		if err != nil {
+			log.Println("Could not render current list:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+
+		err = respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("%s\n\n%s has been added to the rotation", list, user.Mention()),
+				Flags:   1 << 6, // Ephemeral, private
+			},
+		})
+		if err != nil {
+			log.Println("Could not respond to user message:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+	}
+}
+
+func rotatorRemove(store state.Backend) commandHandler {
+	return func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+		var user *discordgo.User
+		if opt := opts.get("username"); opt != nil {
+			user = opt.UserValue(nil)
+		}
+
+		if user == nil {
+			log.Println("A user must be provided")
+			commandError(ctx, s, i.Interaction, errors.New("a user must be provided"))
+			return
+		}
+
+		rot := newGuildRotator(i.ChannelID, store, i.GuildID)
+		if err := rot.RemoveUser(ctx, user.ID); err != nil {
+			log.Println("Could not remove user from rotation:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+
+		list, err := rot.ListFormatted(ctx, s)
+		if err != nil {
+			log.Println("Could not render current list:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+
+		err = respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("%s\n\n%s has been removed from the rotation", list, user.Mention()),
+				Flags:   1 << 6, // Ephemeral, private
+			},
+		})
+		if err != nil {
+			log.Println("Could not respond to user message:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+	}
+}
+
+func rotatorAdvance(store state.Backend) commandHandler {
+	return func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+		var reverse bool
+		if opt := opts.get("reverse"); opt != nil {
+			reverse = opt.BoolValue()
+		}
+
+		rot := newGuildRotator(i.ChannelID, store, i.GuildID)
+		user, err := rot.Advance(ctx, reverse)
+		if err != nil {
+			log.Println("Could not advance rotation:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+
+		list, err := rot.ListFormatted(ctx, s)
+		if err != nil {
+			log.Println("Could not render current list:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+
+		err = respond(ctx, s, i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("%s\n\n%s is now assigned in the rotation!", list, user.resolve(ctx, s).Mention()),
+			},
+		})
+		if err != nil {
+			log.Println("Could not respond to user message:", err)
+			commandError(ctx, s, i.Interaction, err)
+			return
+		}
+	}
+}
+
 func newRotator(channel string, store state.Backend) *rotator {
 	return &rotator{
 		channel: channel,
@@ -241,6 +280,14 @@ func newRotator(channel string, store state.Backend) *rotator {
 	}
 }
 
+// newGuildRotator returns the rotator for a channel in a guild, which moves the
+// channel's rotation into the guild's namespace if it hasn't been already.
+func newGuildRotator(channel string, store state.Backend, guildID string) *rotator {
+	r := newRotator(channel, guildStore(store, guildID))
+	r.legacy, r.guildID = store, guildID
+	return r
+}
+
 func (r *rotator) Current(ctx context.Context) (rotationUser, error) {
 	data, err := r.getRotation(ctx)
 	if err != nil {
@@ -264,7 +311,7 @@ func (r *rotator) ListFormatted(ctx context.Context, s rotatorSession) (string,
 
 	ret := []string{}
 	for i, user := range data.Users {
-		add := user.resolve(s).Username
+		add := user.resolve(ctx, s).Username
 		if i == data.Current {
 			add = "**" + add + "**"
 		}
@@ -276,96 +323,132 @@ func (r *rotator) ListFormatted(ctx context.Context, s rotatorSession) (string,
 }
 
 func (r *rotator) Advance(ctx context.Context, reverse bool) (rotationUser, error) {
-	data, err := r.getRotation(ctx)
-	if err != nil {
-		return rotationUser{}, fmt.Errorf("unable to get current rotation: %w", err)
-	} else if len(data.Users) == 0 {
-		return rotationUser{}, errors.New("no users currently in rotation")
-	}
-
-	if reverse {
-		data.Current--
-		if data.Current < 0 {
-			data.Current = len(data.Users) - 1
-		}
-	} else {
-		data.Current++
-		if data.Current >= len(data.Users) {
-			data.Current = 0
+	var user rotationUser
+	err := r.updateRotation(ctx, func(data *rotation) error {
+		if len(data.Users) == 0 {
+			return errors.New("no users currently in rotation")
 		}
-	}
 
-	data.Users[data.Current].LastAssigned = time.Now()
-	return data.Users[data.Current], r.setRotation(ctx, data)
+		data.advance(reverse)
+		user = data.Users[data.Current]
+		return nil
+	})
+
+	return user, err
 }
 
 func (r *rotator) AddUser(ctx context.Context, user discordgo.User) error {
-	data, err := r.getRotation(ctx)
-	if err != nil {
-		return fmt.Errorf("unable to get current rotation: %w", err)
-	}
-
-	for _, newID := range data.Users {
-		if newID.ID == user.ID {
-			return errors.New("user is already in the rotation")
+	return r.updateRotation(ctx, func(data *rotation) error {
+		for _, newID := range data.Users {
+			if newID.ID == user.ID {
+				return errors.New("user is already in the rotation")
+			}
 		}
-	}
 
-	add := rotationUser{ID: user.ID}
+		add := rotationUser{ID: user.ID}
 
-	// If this is the first user to be added, they're automatically the current!
-	if len(data.Users) == 0 {
-		add.LastAssigned = time.Now()
-	}
+		// If this is the first user to be added, they're automatically the current!
+		if len(data.Users) == 0 {
+			add.LastAssigned = time.Now()
+		}
 
-	data.Users = append(data.Users, add)
-	return r.setRotation(ctx, data)
+		data.Users = append(data.Users, add)
+		return nil
+	})
 }
 
 func (r *rotator) RemoveUser(ctx context.Context, id string) error {
-	data, err := r.getRotation(ctx)
-	if err != nil {
-		return fmt.Errorf("unable to get current rotation: %w", err)
-	}
-
-	for i := range data.Users {
-		if data.Users[i].ID == id {
-			data.Users = append(data.Users[0:i], data.Users[i+1:]...)
-
-			// If we removed the current user, then advance to the next user
-			if i == data.Current {
-				data.Current--
-				if err := r.setRotation(ctx, data); err != nil {
-					return err
+	return r.updateRotation(ctx, func(data *rotation) error {
+		for i := range data.Users {
+			if data.Users[i].ID == id {
+				data.Users = append(data.Users[0:i], data.Users[i+1:]...)
+
+				// If we removed the current user, then advance to the next user
+				if i == data.Current {
+					data.Current--
+					data.advance(false)
 				}
-				_, err = r.Advance(ctx, false)
-				return err
-			}
 
-			break
+				break
+			}
 		}
-	}
 
-	return r.setRotation(ctx, data)
+		return nil
+	})
 }
 
 func (r *rotator) getRotation(ctx context.Context) (rotation, error) {
+	if err := r.adoptLegacy(ctx); err != nil {
+		return rotation{}, err
+	}
+
 	data := rotation{}
 	err := r.store.Get(ctx, r.prefix+"rotation", &data)
-	if err != nil {
-		log.Printf("Rotation not found for %q. Creating an empty one", r.prefix)
-		err = r.store.Set(ctx, r.prefix+"rotation", &data)
+	if errors.Is(err, state.ErrNotFound) {
+		err = nil
 	}
 
 	return data, err
 }
 
-func (r *rotator) setRotation(ctx context.Context, data rotation) error {
-	return r.store.Set(ctx, r.prefix+"rotation", &data)
+// updateRotation atomically applies fn to the stored rotation, creating an empty
+// one if the channel doesn't have a rotation yet.
+func (r *rotator) updateRotation(ctx context.Context, fn func(data *rotation) error) error {
+	if err := r.adoptLegacy(ctx); err != nil {
+		return err
+	}
+
+	data := rotation{}
+	err := r.store.Update(ctx, r.prefix+"rotation", &data, state.NoExpiry, func() error {
+		return fn(&data)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update rotation: %w", err)
+	}
+
+	return nil
+}
+
+// adoptLegacy moves the channel's rotation from before state was namespaced
+// into the guild, so that it isn't hidden from commands until the leader gets
+// round to migrating it. A guild that already has its own rotation keeps it.
+func (r *rotator) adoptLegacy(ctx context.Context) error {
+	if r.legacy == nil {
+		return nil
+	}
+
+	_, err := moveLegacyKey(ctx, r.legacy, r.guildID, r.prefix+"rotation")
+	if err != nil && !errors.Is(err, errAlreadyMoved) {
+		return fmt.Errorf("unable to move rotation into the guild: %w", err)
+	}
+	return nil
+}
+
+// advance moves the rotation one step forward (or backward), wrapping around
+// either end and marking the newly current user as assigned.
+func (data *rotation) advance(reverse bool) {
+	if len(data.Users) == 0 {
+		data.Current = 0
+		return
+	}
+
+	if reverse {
+		data.Current--
+		if data.Current < 0 {
+			data.Current = len(data.Users) - 1
+		}
+	} else {
+		data.Current++
+		if data.Current >= len(data.Users) {
+			data.Current = 0
+		}
+	}
+
+	data.Users[data.Current].LastAssigned = time.Now()
 }
 
-func (u *rotationUser) resolve(s rotatorSession) *discordgo.User {
-	user, err := s.User(u.ID)
+func (u *rotationUser) resolve(ctx context.Context, s rotatorSession) *discordgo.User {
+	user, err := s.User(u.ID, discordgo.WithContext(ctx))
 	if err != nil {
 		return &discordgo.User{Username: "Unknown"}
 	}
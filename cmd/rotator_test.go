@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -54,7 +55,7 @@ func Test_rotator_Current(t *testing.T) {
 			r := &rotator{
 				store: state.NewMemory(),
 			}
-			r.store.Set(context.Background(), "rotation", tt.r)
+			r.store.Set(context.Background(), "rotation", tt.r, state.NoExpiry)
 
 			got, err := r.Current(context.Background())
 			if (err != nil) != tt.wantErr {
@@ -110,7 +111,7 @@ func Test_rotator_ListFormatted(t *testing.T) {
 			r := &rotator{
 				store: state.NewMemory(),
 			}
-			r.store.Set(context.Background(), "rotation", tt.r)
+			r.store.Set(context.Background(), "rotation", tt.r, state.NoExpiry)
 
 			got, err := r.ListFormatted(context.Background(), tt.s)
 			if (err != nil) != tt.wantErr {
@@ -198,7 +199,7 @@ func Test_rotator_Advance(t *testing.T) {
 			r := &rotator{
 				store: state.NewMemory(),
 			}
-			r.store.Set(context.Background(), "rotation", tt.r)
+			r.store.Set(context.Background(), "rotation", tt.r, state.NoExpiry)
 
 			got, err := r.Advance(context.Background(), tt.reverse)
 			if (err != nil) != tt.wantErr {
@@ -214,6 +215,37 @@ func Test_rotator_Advance(t *testing.T) {
 	}
 }
 
+func Test_rotator_Advance_concurrent(t *testing.T) {
+	const advances = 50
+
+	r := &rotator{
+		store: state.NewMemory(),
+	}
+	r.store.Set(context.Background(), "rotation", rotation{
+		Users: []rotationUser{{ID: "123"}, {ID: "456"}, {ID: "789"}},
+	}, state.NoExpiry)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < advances; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Advance(context.Background(), false); err != nil {
+				t.Errorf("rotator.Advance() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := r.Current(context.Background())
+	if err != nil {
+		t.Fatalf("rotator.Current() error = %v", err)
+	}
+	if want := []string{"123", "456", "789"}[advances%3]; got.ID != want {
+		t.Errorf("rotator.Current() = %v, want %v", got.ID, want)
+	}
+}
+
 func Test_rotator_AddUser(t *testing.T) {
 	type args struct {
 		user discordgo.User
@@ -264,7 +296,7 @@ func Test_rotator_AddUser(t *testing.T) {
 			r := &rotator{
 				store: state.NewMemory(),
 			}
-			r.store.Set(context.Background(), "rotation", tt.r)
+			r.store.Set(context.Background(), "rotation", tt.r, state.NoExpiry)
 
 			if err := r.AddUser(context.Background(), tt.args.user); (err != nil) != tt.wantErr {
 				t.Errorf("rotator.AddUser() error = %v, wantErr %v", err, tt.wantErr)
@@ -389,7 +421,7 @@ func Test_rotator_RemoveUser(t *testing.T) {
 			r := &rotator{
 				store: state.NewMemory(),
 			}
-			r.store.Set(context.Background(), "rotation", tt.r)
+			r.store.Set(context.Background(), "rotation", tt.r, state.NoExpiry)
 
 			if err := r.RemoveUser(context.Background(), tt.args.id); (err != nil) != tt.wantErr {
 				t.Errorf("rotator.RemoveUser() error = %v, wantErr %v", err, tt.wantErr)
@@ -415,3 +447,155 @@ func Test_rotator_RemoveUser(t *testing.T) {
 		})
 	}
 }
+
+type failingBackend struct {
+	state.Backend
+	err  error
+	sets int
+}
+
+func (f *failingBackend) Get(ctx context.Context, key string, value interface{}) error {
+	return f.err
+}
+
+func (f *failingBackend) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	f.sets++
+	return nil
+}
+
+func Test_rotator_getRotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{
+			name: "not-found",
+			err:  state.ErrNotFound,
+		},
+		{
+			name:    "backend-failure",
+			err:     errors.New("connection refused"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingBackend{err: tt.err}
+			r := &rotator{store: store}
+
+			_, err := r.getRotation(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("rotator.getRotation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if store.sets != 0 {
+				t.Errorf("rotator.getRotation() sets = %d, want 0", store.sets)
+			}
+		})
+	}
+}
+
+func Test_rotatorCommands(t *testing.T) {
+	f := newFakeGuild()
+	admin := f.addMember("1", "admin", discordgo.PermissionManageChannels)
+	alice := f.addMember("2", "alice", 0)
+	channel := f.addChannel("general")
+	c := newCommands(f, state.NewMemory())
+
+	steps := []struct {
+		name   string
+		member *discordgo.Member
+		sub    *discordgo.ApplicationCommandInteractionDataOption
+		want   string
+		public bool
+	}{
+		{
+			name:   "show empty",
+			member: alice,
+			sub:    subcommandOption("show"),
+			want:   ":warning: no users currently in rotation",
+		},
+		{
+			name:   "add first",
+			member: admin,
+			sub:    subcommandOption("add", userOption("username", alice.User.ID)),
+			want:   "[ **alice** ]\n\n<@2> has been added to the rotation",
+		},
+		{
+			name:   "add second",
+			member: admin,
+			sub:    subcommandOption("add", userOption("username", admin.User.ID)),
+			want:   "[ **alice** :fast_forward: admin ]\n\n<@1> has been added to the rotation",
+		},
+		{
+			name:   "add duplicate",
+			member: admin,
+			sub:    subcommandOption("add", userOption("username", admin.User.ID)),
+			want:   ":warning: unable to update rotation: user is already in the rotation",
+		},
+		{
+			name:   "advance without permission",
+			member: alice,
+			sub:    subcommandOption("advance"),
+			want:   ":warning: you need the Manage Channels permission to use /rotator advance",
+		},
+		{
+			name:   "advance",
+			member: admin,
+			sub:    subcommandOption("advance"),
+			want:   "[ alice :fast_forward: **admin** ]\n\n<@1> is now assigned in the rotation!",
+			public: true,
+		},
+		{
+			name:   "remove current",
+			member: admin,
+			sub:    subcommandOption("remove", userOption("username", admin.User.ID)),
+			want:   "[ **alice** ]\n\n<@1> has been removed from the rotation",
+		},
+	}
+	for _, step := range steps {
+		i := f.command(step.member, channel.ID, "rotator", step.sub)
+		c.handleCommand(f, i)
+
+		replies := f.replies(i.ID)
+		if len(replies) != 1 {
+			t.Fatalf("%s: got %d replies, want 1", step.name, len(replies))
+		}
+		if replies[0].Content != step.want {
+			t.Errorf("%s: reply = %q, want %q", step.name, replies[0].Content, step.want)
+		}
+		if public := replies[0].Flags&discordgo.MessageFlagsEphemeral == 0; public != step.public {
+			t.Errorf("%s: public = %v, want %v", step.name, public, step.public)
+		}
+	}
+}
+
+func Test_rotatorCommands_legacy(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeGuild()
+	admin := f.addMember("1", "admin", discordgo.PermissionManageChannels)
+	alice := f.addMember("2", "alice", 0)
+	channel := f.addChannel("general")
+	store := state.NewMemory()
+	c := newCommands(f, store)
+
+	// A rotation from before state was namespaced, not yet migrated
+	legacyKey := "rotator/" + channel.ID + "/rotation"
+	store.Set(ctx, legacyKey, rotation{Users: []rotationUser{{ID: alice.User.ID}}}, state.NoExpiry)
+
+	i := f.command(admin, channel.ID, "rotator", subcommandOption("add", userOption("username", admin.User.ID)))
+	c.handleCommand(f, i)
+	replies := f.replies(i.ID)
+	want := "[ **alice** :fast_forward: admin ]\n\n<@1> has been added to the rotation"
+	if len(replies) != 1 || replies[0].Content != want {
+		t.Fatalf("add replies = %+v, want %q", replies, want)
+	}
+
+	if err := store.Get(ctx, legacyKey, &rotation{}); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("legacy rotation Get() error = %v, want it moved", err)
+	}
+	moved := rotation{}
+	if err := store.Get(ctx, guildPrefix(f.id)+legacyKey, &moved); err != nil || len(moved.Users) != 2 {
+		t.Errorf("guild rotation = %+v, %v, want both users", moved, err)
+	}
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+type (
+	// commandOptions are the options supplied to a command or, for commands
+	// with subcommands, to the subcommand that was invoked.
+	commandOptions []*discordgo.ApplicationCommandInteractionDataOption
+
+	commandHandler      func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions)
+	autocompleteHandler func(ctx context.Context, s session, i *discordgo.InteractionCreate, o *discordgo.ApplicationCommandInteractionDataOption) []*discordgo.ApplicationCommandOptionChoice
+
+	// componentHandler receives the parts of the CustomID following the prefix it
+	// was registered under. A handler registered as "poll:vote" is passed ["3"]
+	// for a button with the CustomID "poll:vote:3".
+	componentHandler func(ctx context.Context, s session, i *discordgo.InteractionCreate, args []string)
+)
+
+// subcommand handles one leaf of a command tree, such as "/rotator add".
+type subcommand struct {
+	Handler      commandHandler
+	Autocomplete autocompleteHandler
+	// Permission restricts the subcommand to members with all of these
+	// permission bits, or a role allowed to use it with /permissions
+	Permission int64
+}
+
+// resolved is the handler chosen for a command interaction.
+type resolved struct {
+	handler      commandHandler
+	autocomplete autocompleteHandler
+	opts         commandOptions
+	// gates must all be passed before the handler may run
+	gates []gate
+}
+
+// customIDSeparator divides the routing prefix and payload of a CustomID.
+const customIDSeparator = ":"
+
+// router dispatches interactions to the command, subcommand or message
+// component handler registered for them.
+type router struct {
+	commands   map[string]applicationCommand
+	components map[string]componentHandler
+	store      state.Backend
+}
+
+func newRouter(commands []applicationCommand, store state.Backend) *router {
+	r := &router{
+		store:      store,
+		commands:   map[string]applicationCommand{},
+		components: map[string]componentHandler{},
+	}
+
+	for _, cmd := range commands {
+		if _, ok := r.commands[cmd.Command.Name]; ok {
+			panic(fmt.Sprintf("application command %q registered twice", cmd.Command.Name))
+		}
+		r.commands[cmd.Command.Name] = cmd
+
+		for prefix, fn := range cmd.MessageComponents {
+			if _, ok := r.components[prefix]; ok {
+				panic(fmt.Sprintf("message component %q registered twice", prefix))
+			}
+			r.components[prefix] = fn
+		}
+	}
+
+	return r
+}
+
+func (r *router) route(ctx context.Context, s session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		res := r.resolve(data)
+		if res.handler == nil {
+			log.Printf("No handler found for command %q", data.Name)
+			return
+		}
+
+		for _, g := range res.gates {
+			if err := authorize(ctx, r.store, i.Interaction, g); err != nil {
+				commandError(ctx, s, i.Interaction, err)
+				return
+			}
+		}
+
+		res.handler(ctx, s, i, res.opts)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		res := r.resolve(i.ApplicationCommandData())
+		if res.autocomplete == nil {
+			return
+		}
+
+		for _, opt := range res.opts {
+			if opt.Focused {
+				choices := res.autocomplete(ctx, s, i, opt)
+				_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+					Data: &discordgo.InteractionResponseData{Choices: choices},
+				}, discordgo.WithContext(ctx))
+			}
+		}
+	case discordgo.InteractionMessageComponent:
+		customID := i.MessageComponentData().CustomID
+		handler, args := r.component(customID)
+		if handler == nil {
+			log.Printf("No handler found for message component %q", customID)
+			return
+		}
+
+		handler(ctx, s, i, args)
+	default:
+		log.Println("Unknown interaction type encountered: ", i.Type)
+	}
+}
+
+// resolve finds the handlers for the invoked command or subcommand, along with
+// the options that were passed to it and the permissions it requires.
+func (r *router) resolve(data discordgo.ApplicationCommandInteractionData) resolved {
+	cmd, ok := r.commands[data.Name]
+	if !ok {
+		return resolved{}
+	}
+
+	gates := []gate{}
+	if cmd.Permission != 0 {
+		gates = append(gates, gate{Path: cmd.Command.Name, Permission: cmd.Permission})
+	}
+
+	path, opts := subcommandPath(data.Options)
+	if path == "" {
+		return resolved{handler: cmd.Handler, autocomplete: cmd.Autocomplete, opts: opts, gates: gates}
+	}
+
+	sub, ok := cmd.Subcommands[path]
+	if !ok {
+		return resolved{}
+	}
+
+	if sub.Permission != 0 {
+		gates = append(gates, gate{Path: cmd.Command.Name + " " + path, Permission: sub.Permission})
+	}
+
+	return resolved{handler: sub.Handler, autocomplete: sub.Autocomplete, opts: opts, gates: gates}
+}
+
+// component finds the handler registered under the longest prefix of the
+// CustomID, returning the remainder of the CustomID as arguments.
+func (r *router) component(customID string) (componentHandler, []string) {
+	parts := strings.Split(customID, customIDSeparator)
+	for n := len(parts); n > 0; n-- {
+		if handler, ok := r.components[strings.Join(parts[:n], customIDSeparator)]; ok {
+			return handler, parts[n:]
+		}
+	}
+
+	return nil, nil
+}
+
+// subcommandPath returns the name of the invoked subcommand, as "group sub" or
+// "sub", along with its options. Commands without subcommands have no path.
+func subcommandPath(opts commandOptions) (string, commandOptions) {
+	if len(opts) == 0 {
+		return "", opts
+	}
+
+	switch opts[0].Type {
+	case discordgo.ApplicationCommandOptionSubCommandGroup:
+		if len(opts[0].Options) == 0 {
+			return opts[0].Name, nil
+		}
+		path, leafOpts := subcommandPath(opts[0].Options)
+		return opts[0].Name + " " + path, leafOpts
+	case discordgo.ApplicationCommandOptionSubCommand:
+		return opts[0].Name, opts[0].Options
+	default:
+		return "", opts
+	}
+}
+
+// get returns the named option, or nil if it wasn't supplied.
+func (o commandOptions) get(name string) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, opt := range o {
+		if opt.Name == name {
+			return opt
+		}
+	}
+
+	return nil
+}
+
+// customID builds a CustomID that routes to the handler registered as prefix,
+// passing it args.
+func customID(prefix string, args ...string) string {
+	return strings.Join(append([]string{prefix}, args...), customIDSeparator)
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+func Test_router_route(t *testing.T) {
+	var called string
+	var gotOpts commandOptions
+	var gotArgs []string
+
+	handler := func(name string) commandHandler {
+		return func(ctx context.Context, s session, i *discordgo.InteractionCreate, opts commandOptions) {
+			called, gotOpts = name, opts
+		}
+	}
+	component := func(name string) componentHandler {
+		return func(ctx context.Context, s session, i *discordgo.InteractionCreate, args []string) {
+			called, gotArgs = name, args
+		}
+	}
+
+	r := newRouter([]applicationCommand{
+		{
+			Command: &discordgo.ApplicationCommand{Name: "poll"},
+			Handler: handler("poll"),
+			MessageComponents: map[string]componentHandler{
+				"poll:vote":            component("vote"),
+				"poll:tiebreaker":      component("tiebreaker"),
+				"pollButtonTiebreaker": component("legacy"),
+			},
+		},
+		{
+			Command: &discordgo.ApplicationCommand{Name: "rotator"},
+			Subcommands: map[string]subcommand{
+				"add":          {Handler: handler("rotator add")},
+				"config reset": {Handler: handler("rotator config reset")},
+			},
+		},
+	}, state.NewMemory())
+
+	user := &discordgo.ApplicationCommandInteractionDataOption{Name: "username", Type: discordgo.ApplicationCommandOptionUser, Value: "1"}
+
+	tests := []struct {
+		name     string
+		data     discordgo.InteractionData
+		want     string
+		wantOpts commandOptions
+		wantArgs []string
+	}{
+		{
+			name:     "command",
+			data:     discordgo.ApplicationCommandInteractionData{Name: "poll", Options: commandOptions{user}},
+			want:     "poll",
+			wantOpts: commandOptions{user},
+		},
+		{
+			name: "subcommand",
+			data: discordgo.ApplicationCommandInteractionData{Name: "rotator", Options: commandOptions{
+				{Name: "add", Type: discordgo.ApplicationCommandOptionSubCommand, Options: commandOptions{user}},
+			}},
+			want:     "rotator add",
+			wantOpts: commandOptions{user},
+		},
+		{
+			name: "subcommand group",
+			data: discordgo.ApplicationCommandInteractionData{Name: "rotator", Options: commandOptions{
+				{Name: "config", Type: discordgo.ApplicationCommandOptionSubCommandGroup, Options: commandOptions{
+					{Name: "reset", Type: discordgo.ApplicationCommandOptionSubCommand},
+				}},
+			}},
+			want: "rotator config reset",
+		},
+		{
+			name: "unknown subcommand",
+			data: discordgo.ApplicationCommandInteractionData{Name: "rotator", Options: commandOptions{
+				{Name: "missing", Type: discordgo.ApplicationCommandOptionSubCommand},
+			}},
+		},
+		{
+			name: "unknown command",
+			data: discordgo.ApplicationCommandInteractionData{Name: "missing"},
+		},
+		{
+			name:     "component with payload",
+			data:     discordgo.MessageComponentInteractionData{CustomID: "poll:vote:3"},
+			want:     "vote",
+			wantArgs: []string{"3"},
+		},
+		{
+			name:     "component without payload",
+			data:     discordgo.MessageComponentInteractionData{CustomID: "poll:tiebreaker"},
+			want:     "tiebreaker",
+			wantArgs: []string{},
+		},
+		{
+			name:     "legacy component",
+			data:     discordgo.MessageComponentInteractionData{CustomID: "pollButtonTiebreaker"},
+			want:     "legacy",
+			wantArgs: []string{},
+		},
+		{
+			name: "unknown component",
+			data: discordgo.MessageComponentInteractionData{CustomID: "poll:missing:3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called, gotOpts, gotArgs = "", nil, nil
+
+			i := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{Data: tt.data}}
+			switch tt.data.(type) {
+			case discordgo.ApplicationCommandInteractionData:
+				i.Type = discordgo.InteractionApplicationCommand
+			case discordgo.MessageComponentInteractionData:
+				i.Type = discordgo.InteractionMessageComponent
+			}
+
+			r.route(context.Background(), nil, i)
+			if called != tt.want {
+				t.Errorf("route() called %q, want %q", called, tt.want)
+			}
+			if !reflect.DeepEqual(gotOpts, tt.wantOpts) {
+				t.Errorf("route() opts = %v, want %v", gotOpts, tt.wantOpts)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("route() args = %v, want %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
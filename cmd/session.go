@@ -0,0 +1,46 @@
+package cmd
+
+import "github.com/bwmarrin/discordgo"
+
+// session is everything the package needs from Discord, allowing handlers to
+// be tested against a fake. It is satisfied by discordSession.
+type session interface {
+	rotatorSession
+	guildSession
+	responderSession
+
+	// BotUser is the bot's own user
+	BotUser() *discordgo.User
+	// Guilds are the guilds the bot is currently in
+	Guilds() []*discordgo.Guild
+
+	GuildRoles(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Role, error)
+	GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error)
+	GuildMemberRoleAdd(guildID, userID, roleID string, options ...discordgo.RequestOption) error
+	GuildMemberRoleRemove(guildID, userID, roleID string, options ...discordgo.RequestOption) error
+
+	ChannelMessagesPinned(channelID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelMessageSend(channelID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEdit(channelID, messageID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessagePin(channelID, messageID string, options ...discordgo.RequestOption) error
+
+	ApplicationCommands(appID, guildID string, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	ApplicationCommandBulkOverwrite(appID, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+}
+
+// discordSession adapts a gateway session, whose cached state isn't reachable
+// through methods, to the session interface.
+type discordSession struct {
+	*discordgo.Session
+}
+
+func (s discordSession) BotUser() *discordgo.User {
+	return s.State.User
+}
+
+func (s discordSession) Guilds() []*discordgo.Guild {
+	s.State.RLock()
+	defer s.State.RUnlock()
+
+	return append([]*discordgo.Guild{}, s.State.Guilds...)
+}
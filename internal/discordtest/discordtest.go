@@ -0,0 +1,372 @@
+// Package discordtest provides a stand-in for Discord's REST API and gateway,
+// so that a real discordgo.Session can be exercised without a network.
+//
+// The server keeps a small model of each guild added to it. REST calls made by
+// the bot update that model, while tests inject gateway events and inspect the
+// result.
+package discordtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// apiPrefix matches the versioned root of every REST endpoint.
+var apiPrefix = regexp.MustCompile(`^/api/v\d+/`)
+
+// Server is a fake Discord. Create one with NewServer.
+type Server struct {
+	// Bot is the user the bot logs in as
+	Bot *discordgo.User
+
+	t   testing.TB
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	nextID    int64
+	guilds    []*discordgo.Guild
+	messages  map[string][]*discordgo.Message
+	pins      map[string][]string
+	commands  map[string][]*discordgo.ApplicationCommand
+	responses map[string][]*discordgo.InteractionResponse
+	waiters   map[string]chan *discordgo.InteractionResponse
+	followups map[string][]*discordgo.Message
+	tokens    map[string]string
+
+	gateway *gateway
+}
+
+// NewServer starts a fake Discord that is shut down at the end of the test.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		Bot:       &discordgo.User{ID: "100", Username: "lil-dumpster", Bot: true},
+		t:         t,
+		nextID:    1000,
+		messages:  map[string][]*discordgo.Message{},
+		pins:      map[string][]string{},
+		commands:  map[string][]*discordgo.ApplicationCommand{},
+		responses: map[string][]*discordgo.InteractionResponse{},
+		waiters:   map[string]chan *discordgo.InteractionResponse{},
+		followups: map[string][]*discordgo.Message{},
+		tokens:    map[string]string{},
+		gateway:   newGateway(),
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Close disconnects the bot and stops the server.
+func (s *Server) Close() {
+	s.gateway.close()
+	s.srv.Close()
+}
+
+// NewSession creates a session that talks to the fake rather than Discord.
+func (s *Server) NewSession(token string) (*discordgo.Session, error) {
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, err
+	}
+
+	target, _ := url.Parse(s.srv.URL)
+	session.Client = &http.Client{Transport: &rewriteTransport{target: target}}
+	session.ShouldReconnectOnError = false
+	return session, nil
+}
+
+// NewID returns a snowflake that is unique within the server.
+func (s *Server) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.newID()
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.FormatInt(s.nextID, 10)
+}
+
+// AddGuild adds a guild, along with its channels, roles and members, which
+// the bot receives when it connects. It must be called before the bot opens
+// its session.
+func (s *Server) AddGuild(g *discordgo.Guild) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, channel := range g.Channels {
+		channel.GuildID = g.ID
+	}
+	for _, member := range g.Members {
+		member.GuildID = g.ID
+	}
+	s.guilds = append(s.guilds, g)
+}
+
+// Connected is closed once the bot has identified and been sent its guilds.
+func (s *Server) Connected() <-chan struct{} {
+	return s.gateway.connected
+}
+
+// Dispatch sends an event, such as "GUILD_DELETE", to the connected bot.
+func (s *Server) Dispatch(event string, data interface{}) error {
+	s.mu.Lock()
+	raw, err := json.Marshal(data)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.gateway.dispatch(event, raw)
+}
+
+// Interact sends an interaction to the bot and waits for its initial
+// response. The interaction is given an ID, token and application ID if it
+// doesn't have them.
+func (s *Server) Interact(ctx context.Context, i *discordgo.Interaction) (*discordgo.InteractionResponse, error) {
+	s.mu.Lock()
+	if i.ID == "" {
+		i.ID = s.newID()
+	}
+	if i.Token == "" {
+		i.Token = "token-" + i.ID
+	}
+	if i.AppID == "" {
+		i.AppID = s.Bot.ID
+	}
+	s.tokens[i.Token] = i.ID
+	waiter := make(chan *discordgo.InteractionResponse, 1)
+	s.waiters[i.ID] = waiter
+	s.mu.Unlock()
+
+	if err := s.Dispatch("INTERACTION_CREATE", i); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-waiter:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no response to interaction %s: %w", i.ID, ctx.Err())
+	}
+}
+
+// Deliver sends an interaction to the bot's HTTP interactions endpoint, signed
+// with key, and returns the initial response written back. The interaction is
+// completed as in Interact.
+func (s *Server) Deliver(ctx context.Context, endpoint string, key ed25519.PrivateKey, i *discordgo.Interaction) (*discordgo.InteractionResponse, error) {
+	s.mu.Lock()
+	if i.ID == "" {
+		i.ID = s.newID()
+	}
+	if i.Token == "" {
+		i.Token = "token-" + i.ID
+	}
+	if i.AppID == "" {
+		i.AppID = s.Bot.ID
+	}
+	s.tokens[i.Token] = i.ID
+	s.mu.Unlock()
+
+	body, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, append([]byte(timestamp), body...))))
+
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	payload, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("interaction %s: %s: %s", i.ID, httpResp.Status, bytes.TrimSpace(payload))
+	}
+	resp, err := decodeResponse(payload, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i.Type != discordgo.InteractionPing {
+		if err := s.recordResponse(i.ID, resp); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// Followups returns the edits and follow-up messages sent for an interaction
+// after its initial response.
+func (s *Server) Followups(interactionID string) []*discordgo.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*discordgo.Message{}, s.followups[interactionID]...)
+}
+
+// Commands returns the application commands registered in a guild, or
+// globally for "".
+func (s *Server) Commands(guildID string) []*discordgo.ApplicationCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*discordgo.ApplicationCommand{}, s.commands[guildID]...)
+}
+
+// Pinned returns the messages pinned in a channel.
+func (s *Server) Pinned(channelID string) []*discordgo.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pinned(channelID)
+}
+
+func (s *Server) pinned(channelID string) []*discordgo.Message {
+	ret := []*discordgo.Message{}
+	for _, id := range s.pins[channelID] {
+		if m := s.message(channelID, id); m != nil {
+			copied := *m
+			ret = append(ret, &copied)
+		}
+	}
+	return ret
+}
+
+// MemberRoles returns the IDs of the roles held by a member of a guild.
+func (s *Server) MemberRoles(guildID, userID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if member := s.member(guildID, userID); member != nil {
+		return append([]string{}, member.Roles...)
+	}
+	return nil
+}
+
+func (s *Server) guild(id string) *discordgo.Guild {
+	for _, g := range s.guilds {
+		if g.ID == id {
+			return g
+		}
+	}
+	return nil
+}
+
+func (s *Server) member(guildID, userID string) *discordgo.Member {
+	if g := s.guild(guildID); g != nil {
+		for _, member := range g.Members {
+			if member.User.ID == userID {
+				return member
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) channel(id string) *discordgo.Channel {
+	for _, g := range s.guilds {
+		for _, channel := range g.Channels {
+			if channel.ID == id {
+				return channel
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) message(channelID, id string) *discordgo.Message {
+	for _, m := range s.messages[channelID] {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// errNotFound is returned by routes for unknown objects.
+var errNotFound = errors.New("404: Not Found")
+
+// errUnknownWebhook is returned, as Discord does, for edits and follow-ups to
+// an interaction that hasn't been acknowledged yet.
+var errUnknownWebhook = errors.New("Unknown Webhook")
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimSuffix(r.URL.Path, "/") == "/gateway" {
+		s.gateway.serve(s, w, r)
+		return
+	}
+
+	if !apiPrefix.MatchString(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.Split(strings.Trim(apiPrefix.ReplaceAllString(r.URL.Path, ""), "/"), "/")
+
+	s.mu.Lock()
+	status, body, err := s.route(r, path)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case errors.Is(err, errUnknownWebhook):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": err.Error(), "code": discordgo.ErrCodeUnknownWebhook})
+	case errors.Is(err, errNotFound):
+		s.t.Logf("discordtest: %s %s not found", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": err.Error(), "code": 0})
+	case err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": err.Error(), "code": 50035})
+	case body == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// rewriteTransport sends every request to the fake instead of Discord.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host, req.Host = t.target.Scheme, t.target.Host, ""
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -0,0 +1,156 @@
+package discordtest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+// heartbeatInterval is long enough that the bot never misses an ack in tests.
+const heartbeatInterval = 45000
+
+var errNotConnected = errors.New("bot is not connected to the gateway")
+
+// gateway is the websocket side of the fake, which the bot connects to after
+// asking the REST API where to find it.
+type gateway struct {
+	upgrader  websocket.Upgrader
+	connected chan struct{}
+	once      sync.Once
+
+	// mu guards conn and seq, and serializes writes to the connection
+	mu   sync.Mutex
+	conn *websocket.Conn
+	seq  int64
+}
+
+// frame is a gateway payload in either direction.
+type frame struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d"`
+	Sequence int64           `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+func newGateway() *gateway {
+	return &gateway{connected: make(chan struct{})}
+}
+
+// serve handles a bot's connection until it closes.
+func (g *gateway) serve(s *Server, w http.ResponseWriter, r *http.Request) {
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.t.Logf("discordtest: failed to upgrade gateway connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	g.mu.Lock()
+	g.conn, g.seq = conn, 0
+	g.mu.Unlock()
+
+	if err := g.handshake(s, conn); err != nil {
+		s.t.Logf("discordtest: gateway handshake failed: %v", err)
+		return
+	}
+	g.once.Do(func() { close(g.connected) })
+
+	for {
+		var f frame
+		if err := conn.ReadJSON(&f); err != nil {
+			return
+		}
+
+		if f.Op == 1 { // Heartbeat
+			if err := g.write(frame{Op: 11}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handshake greets the bot, waits for it to identify and then sends it the
+// guilds it belongs to.
+func (g *gateway) handshake(s *Server, conn *websocket.Conn) error {
+	hello, _ := json.Marshal(map[string]int{"heartbeat_interval": heartbeatInterval})
+	if err := g.write(frame{Op: 10, Data: hello}); err != nil {
+		return err
+	}
+
+	var identify frame
+	if err := conn.ReadJSON(&identify); err != nil {
+		return err
+	}
+	if identify.Op != 2 {
+		return errors.New("expected the bot to identify")
+	}
+
+	s.mu.Lock()
+	stubs := []*discordgo.Guild{}
+	for _, guild := range s.guilds {
+		stubs = append(stubs, &discordgo.Guild{ID: guild.ID, Unavailable: true})
+	}
+	ready, err := json.Marshal(map[string]interface{}{
+		"v":           9,
+		"session_id":  "session",
+		"user":        s.Bot,
+		"application": map[string]string{"id": s.Bot.ID},
+		"guilds":      stubs,
+	})
+	guilds := [][]byte{}
+	for _, guild := range s.guilds {
+		raw, _ := json.Marshal(guild)
+		guilds = append(guilds, raw)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := g.dispatch("READY", ready); err != nil {
+		return err
+	}
+	for _, raw := range guilds {
+		if err := g.dispatch("GUILD_CREATE", raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatch sends an event to the connected bot.
+func (g *gateway) dispatch(event string, data json.RawMessage) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn == nil {
+		return errNotConnected
+	}
+	g.seq++
+	return g.conn.WriteJSON(frame{Op: 0, Sequence: g.seq, Type: event, Data: data})
+}
+
+func (g *gateway) write(f frame) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn == nil {
+		return errNotConnected
+	}
+	return g.conn.WriteJSON(f)
+}
+
+// close disconnects the bot, if it is connected.
+func (g *gateway) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
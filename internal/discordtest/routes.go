@@ -0,0 +1,310 @@
+package discordtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// route applies a REST request to the model. It is called with s.mu held, and
+// returns the status and body of the response. A nil body means no content.
+func (s *Server) route(r *http.Request, path []string) (int, interface{}, error) {
+	switch {
+	case match(r, path, "GET", "gateway"):
+		return http.StatusOK, map[string]string{"url": "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/gateway"}, nil
+
+	case match(r, path, "GET", "users", "*"):
+		if path[1] == "@me" || path[1] == s.Bot.ID {
+			return http.StatusOK, s.Bot, nil
+		}
+		for _, g := range s.guilds {
+			if member := s.member(g.ID, path[1]); member != nil {
+				return http.StatusOK, member.User, nil
+			}
+		}
+
+	case match(r, path, "GET", "users", "@me", "guilds"):
+		guilds := []*discordgo.UserGuild{}
+		for _, g := range s.guilds {
+			guilds = append(guilds, &discordgo.UserGuild{ID: g.ID, Name: g.Name})
+		}
+		return http.StatusOK, guilds, nil
+
+	case match(r, path, "GET", "channels", "*"):
+		if channel := s.channel(path[1]); channel != nil {
+			return http.StatusOK, channel, nil
+		}
+
+	case match(r, path, "POST", "channels", "*", "messages"):
+		if s.channel(path[1]) == nil {
+			break
+		}
+		m, err := decodeMessage(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		m.ID, m.ChannelID, m.Author = s.newID(), path[1], s.Bot
+		s.messages[path[1]] = append(s.messages[path[1]], m)
+		return http.StatusOK, m, nil
+
+	case match(r, path, "PATCH", "channels", "*", "messages", "*"):
+		existing := s.message(path[1], path[3])
+		if existing == nil {
+			break
+		}
+		m, err := decodeMessage(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		existing.Content, existing.Components = m.Content, m.Components
+		return http.StatusOK, existing, nil
+
+	case match(r, path, "GET", "channels", "*", "pins"):
+		return http.StatusOK, s.pinned(path[1]), nil
+
+	case match(r, path, "PUT", "channels", "*", "pins", "*"):
+		if s.message(path[1], path[3]) == nil {
+			break
+		}
+		s.pins[path[1]] = append(s.pins[path[1]], path[3])
+		return 0, nil, nil
+
+	case match(r, path, "GET", "guilds", "*", "roles"):
+		if g := s.guild(path[1]); g != nil {
+			return http.StatusOK, g.Roles, nil
+		}
+
+	case match(r, path, "GET", "guilds", "*", "channels"):
+		if g := s.guild(path[1]); g != nil {
+			return http.StatusOK, g.Channels, nil
+		}
+
+	case match(r, path, "PUT", "guilds", "*", "members", "*", "roles", "*"),
+		match(r, path, "DELETE", "guilds", "*", "members", "*", "roles", "*"):
+		member := s.member(path[1], path[3])
+		if member == nil {
+			break
+		}
+		roles := []string{}
+		for _, role := range member.Roles {
+			if role != path[5] {
+				roles = append(roles, role)
+			}
+		}
+		if r.Method == http.MethodPut {
+			roles = append(roles, path[5])
+		}
+		member.Roles = roles
+		return 0, nil, nil
+
+	case match(r, path, "GET", "applications", "*", "commands"):
+		return http.StatusOK, s.registered(""), nil
+
+	case match(r, path, "GET", "applications", "*", "guilds", "*", "commands"):
+		return http.StatusOK, s.registered(path[3]), nil
+
+	case match(r, path, "PUT", "applications", "*", "commands"):
+		return s.overwriteCommands(r, path[1], "")
+
+	case match(r, path, "PUT", "applications", "*", "guilds", "*", "commands"):
+		return s.overwriteCommands(r, path[1], path[3])
+
+	case match(r, path, "POST", "interactions", "*", "*", "callback"):
+		return s.respond(r, path[1])
+
+	case match(r, path, "PATCH", "webhooks", "*", "*", "messages", "*"),
+		match(r, path, "POST", "webhooks", "*", "*"):
+		interactionID, ok := s.tokens[path[2]]
+		if !ok {
+			break
+		}
+		if len(s.responses[interactionID]) == 0 {
+			return 0, nil, errUnknownWebhook
+		}
+		m, err := decodeMessage(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		m.ID = s.newID()
+		s.followups[interactionID] = append(s.followups[interactionID], m)
+		return http.StatusOK, m, nil
+
+	case match(r, path, "DELETE", "webhooks", "*", "*", "messages", "*"):
+		interactionID, ok := s.tokens[path[2]]
+		if !ok {
+			break
+		}
+		if len(s.responses[interactionID]) == 0 {
+			return 0, nil, errUnknownWebhook
+		}
+		return 0, nil, nil
+	}
+
+	return 0, nil, errNotFound
+}
+
+// match reports whether the request has the method and path, where "*"
+// matches any one segment.
+func match(r *http.Request, path []string, method string, pattern ...string) bool {
+	if r.Method != method || len(path) != len(pattern) {
+		return false
+	}
+
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) registered(guildID string) []*discordgo.ApplicationCommand {
+	return append([]*discordgo.ApplicationCommand{}, s.commands[guildID]...)
+}
+
+func (s *Server) overwriteCommands(r *http.Request, appID, guildID string) (int, interface{}, error) {
+	commands := []*discordgo.ApplicationCommand{}
+	if err := json.NewDecoder(r.Body).Decode(&commands); err != nil {
+		return 0, nil, err
+	}
+
+	for _, cmd := range commands {
+		cmd.ID, cmd.ApplicationID, cmd.GuildID, cmd.Version = s.newID(), appID, guildID, "1"
+		if cmd.Type == 0 {
+			cmd.Type = discordgo.ChatApplicationCommand
+		}
+	}
+	s.commands[guildID] = commands
+	return http.StatusOK, commands, nil
+}
+
+// respond records the initial response to an interaction sent through the
+// callback endpoint and hands it to the test waiting in Interact.
+func (s *Server) respond(r *http.Request, interactionID string) (int, interface{}, error) {
+	payload, files, err := readPayload(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := decodeResponse(payload, files)
+	if err != nil {
+		return 0, nil, err
+	}
+	return 0, nil, s.recordResponse(interactionID, resp)
+}
+
+// recordResponse records the initial response to an interaction. It is called
+// with s.mu held.
+func (s *Server) recordResponse(interactionID string, resp *discordgo.InteractionResponse) error {
+	if len(s.responses[interactionID]) > 0 {
+		return fmt.Errorf("interaction %s has already been acknowledged", interactionID)
+	}
+	s.responses[interactionID] = append(s.responses[interactionID], resp)
+
+	if waiter, ok := s.waiters[interactionID]; ok {
+		waiter <- resp
+		delete(s.waiters, interactionID)
+	}
+	return nil
+}
+
+func decodeResponse(payload []byte, files []*discordgo.File) (*discordgo.InteractionResponse, error) {
+	raw := struct {
+		Type discordgo.InteractionResponseType `json:"type"`
+		Data json.RawMessage                   `json:"data"`
+	}{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+
+	resp := &discordgo.InteractionResponse{Type: raw.Type}
+	if len(raw.Data) > 0 && string(raw.Data) != "null" {
+		// Messages know how to decode their components
+		m := &discordgo.Message{}
+		choices := struct {
+			Choices []*discordgo.ApplicationCommandOptionChoice `json:"choices"`
+		}{}
+		if err := json.Unmarshal(raw.Data, m); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw.Data, &choices); err != nil {
+			return nil, err
+		}
+
+		resp.Data = &discordgo.InteractionResponseData{
+			Content:    m.Content,
+			Components: m.Components,
+			Flags:      m.Flags,
+			Choices:    choices.Choices,
+			Files:      files,
+		}
+	}
+	return resp, nil
+}
+
+// decodeMessage reads a message from a request, with any attached files
+// listed as its attachments.
+func decodeMessage(r *http.Request) (*discordgo.Message, error) {
+	payload, files, err := readPayload(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &discordgo.Message{}
+	if err := json.Unmarshal(payload, m); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		m.Attachments = append(m.Attachments, &discordgo.MessageAttachment{
+			Filename:    file.Name,
+			ContentType: file.ContentType,
+		})
+	}
+	return m, nil
+}
+
+// readPayload returns the JSON body of a request, which is sent alongside any
+// attached files in multipart requests.
+func readPayload(r *http.Request) ([]byte, []*discordgo.File, error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		body, err := io.ReadAll(r.Body)
+		return body, nil, err
+	}
+
+	var payload []byte
+	files := []*discordgo.File{}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if part.FormName() == "payload_json" {
+			payload = data
+		} else {
+			files = append(files, &discordgo.File{
+				Name:        part.FileName(),
+				ContentType: part.Header.Get("Content-Type"),
+				Reader:      bytes.NewReader(data),
+			})
+		}
+	}
+
+	return payload, files, nil
+}
@@ -0,0 +1,99 @@
+package state_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state/statetest"
+)
+
+func TestMemory(t *testing.T) {
+	statetest.Run(t, statetest.Harness{
+		New: func(t *testing.T) state.Backend {
+			return state.NewMemory()
+		},
+	})
+}
+
+func TestRedis(t *testing.T) {
+	m := miniredis.RunT(t)
+
+	statetest.Run(t, statetest.Harness{
+		New: func(t *testing.T) state.Backend {
+			m.FlushAll()
+			return state.NewRedis(&redis.Options{Addr: m.Addr()})
+		},
+		Elapse: m.FastForward,
+	})
+}
+
+func TestFile(t *testing.T) {
+	statetest.Run(t, statetest.Harness{
+		New: func(t *testing.T) state.Backend {
+			s, err := state.NewFile(filepath.Join(t.TempDir(), "state.log"))
+			if err != nil {
+				t.Fatalf("NewFile() error = %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+
+			return s
+		},
+	})
+}
+
+func TestVersioned(t *testing.T) {
+	statetest.Run(t, statetest.Harness{
+		New: func(t *testing.T) state.Backend {
+			return state.NewVersioned(state.NewMemory(), &state.Migrations{})
+		},
+	})
+}
+
+func TestPrefixed(t *testing.T) {
+	statetest.Run(t, statetest.Harness{
+		New: func(t *testing.T) state.Backend {
+			inner := state.NewMemory()
+
+			// Keys outside of the prefix must never be visible
+			inner.Set(context.Background(), "other/key", "hidden", state.NoExpiry)
+			inner.Set(context.Background(), "prefix", "hidden", state.NoExpiry)
+
+			return state.NewPrefixed(inner, "prefix/")
+		},
+	})
+}
+
+func TestCache(t *testing.T) {
+	statetest.Run(t, statetest.Harness{
+		New: func(t *testing.T) state.Backend {
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			s, err := state.NewCache(ctx, state.NewMemory(), 2, time.Minute)
+			if err != nil {
+				t.Fatalf("NewCache() error = %v", err)
+			}
+
+			return s
+		},
+	})
+}
+
+func TestEncrypted(t *testing.T) {
+	statetest.Run(t, statetest.Harness{
+		New: func(t *testing.T) state.Backend {
+			s, err := state.NewEncrypted(state.NewMemory(), bytes.Repeat([]byte{1}, 32))
+			if err != nil {
+				t.Fatalf("NewEncrypted() error = %v", err)
+			}
+
+			return s
+		},
+	})
+}
@@ -0,0 +1,145 @@
+package state
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache is a Backend decorator that keeps recently read values in memory. It
+// holds at most size values, each for no longer than maxAge. Values are
+// dropped whenever they're written through the Cache or reported as changed by
+// the underlying Backend's Watch, so writes from other processes are picked up
+// promptly; maxAge bounds the staleness should an event be missed or a key
+// expire.
+type Cache struct {
+	Backend
+	size   int
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	// generation is incremented on every invalidation so that a read racing
+	// with a write doesn't cache the value it replaced.
+	generation uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   json.RawMessage
+	expires time.Time
+}
+
+// NewCache wraps b with a cache, watching for changes until ctx is done.
+func NewCache(ctx context.Context, b Backend, size int, maxAge time.Duration) (*Cache, error) {
+	s := &Cache{
+		Backend: b,
+		size:    size,
+		maxAge:  maxAge,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+
+	events, err := b.Watch(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch for changes: %w", err)
+	}
+
+	go func() {
+		for e := range events {
+			s.invalidate(e.Key)
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *Cache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	defer s.invalidate(key)
+	return s.Backend.Set(ctx, key, value, ttl)
+}
+
+func (s *Cache) Get(ctx context.Context, key string, value interface{}) error {
+	if data, ok := s.lookup(key); ok {
+		return json.Unmarshal(data, value)
+	}
+
+	s.mu.Lock()
+	generation := s.generation
+	s.mu.Unlock()
+
+	data := json.RawMessage{}
+	if err := s.Backend.Get(ctx, key, &data); err != nil {
+		return err
+	}
+
+	s.store(key, data, generation)
+	return json.Unmarshal(data, value)
+}
+
+func (s *Cache) Update(ctx context.Context, key string, value interface{}, ttl time.Duration, fn func() error) error {
+	defer s.invalidate(key)
+	return s.Backend.Update(ctx, key, value, ttl, fn)
+}
+
+func (s *Cache) Delete(ctx context.Context, key string) error {
+	defer s.invalidate(key)
+	return s.Backend.Delete(ctx, key)
+}
+
+func (s *Cache) lookup(key string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expires) {
+		s.remove(elem)
+		return nil, false
+	}
+
+	s.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (s *Cache) store(key string, data json.RawMessage, generation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size <= 0 || generation != s.generation {
+		return
+	}
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+
+	s.entries[key] = s.lru.PushFront(&cacheEntry{key: key, value: data, expires: time.Now().Add(s.maxAge)})
+	for s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *Cache) invalidate(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+}
+
+func (s *Cache) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*cacheEntry).key)
+}
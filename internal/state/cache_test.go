@@ -0,0 +1,88 @@
+package state
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingBackend struct {
+	Backend
+	gets int32
+}
+
+func (c *countingBackend) Get(ctx context.Context, key string, value interface{}) error {
+	atomic.AddInt32(&c.gets, 1)
+	return c.Backend.Get(ctx, key, value)
+}
+
+func TestCache_Get(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &countingBackend{Backend: NewMemory()}
+	s, err := NewCache(ctx, inner, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("NewCache() error = %v", err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		s.Set(ctx, key, key, NoExpiry)
+	}
+
+	read := func(key string) string {
+		got := ""
+		if err := s.Get(ctx, key, &got); err != nil {
+			t.Fatalf("Cache.Get(%q) error = %v", key, err)
+		}
+		return got
+	}
+
+	// Repeated reads are served from the cache
+	read("a")
+	read("a")
+	if inner.gets != 1 {
+		t.Errorf("backend gets = %d, want 1", inner.gets)
+	}
+
+	// The least recently used value is evicted once full
+	read("b")
+	read("c")
+	read("a")
+	if inner.gets != 4 {
+		t.Errorf("backend gets after eviction = %d, want 4", inner.gets)
+	}
+
+	// Writes through the cache invalidate it
+	s.Set(ctx, "a", "updated", NoExpiry)
+	if got := read("a"); got != "updated" {
+		t.Errorf("Cache.Get() after Set() = %q, want updated", got)
+	}
+
+	// As do writes made by other processes
+	inner.Set(ctx, "a", "external", NoExpiry)
+	deadline := time.Now().Add(time.Second)
+	for read("a") != "external" {
+		if time.Now().After(deadline) {
+			t.Fatal("Cache.Get() never observed external write")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCache_maxAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &countingBackend{Backend: NewMemory()}
+	s, _ := NewCache(ctx, inner, 10, time.Millisecond)
+	s.Set(ctx, "a", "a", NoExpiry)
+
+	s.Get(ctx, "a", new(string))
+	time.Sleep(time.Millisecond * 2)
+	s.Get(ctx, "a", new(string))
+	if inner.gets != 2 {
+		t.Errorf("backend gets = %d, want 2", inner.gets)
+	}
+}
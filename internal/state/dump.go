@@ -0,0 +1,82 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// dumpVersion identifies the layout of exported files.
+const dumpVersion = 1
+
+// dump is the portable file format produced by Export. Values are stored as
+// plain JSON so that a dump may be inspected or edited by hand.
+type dump struct {
+	Version  int                        `json:"version"`
+	Exported time.Time                  `json:"exported"`
+	Values   map[string]json.RawMessage `json:"values"`
+}
+
+// Export writes every key beginning with prefix to w, returning the number of
+// keys written. If include is non-nil only the keys it accepts are written.
+// Expiry times are not preserved.
+func Export(ctx context.Context, b Backend, w io.Writer, prefix string, include func(key string) bool) (int, error) {
+	keys, err := b.List(ctx, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list keys: %w", err)
+	}
+
+	d := dump{Version: dumpVersion, Exported: time.Now().UTC(), Values: map[string]json.RawMessage{}}
+	for _, key := range keys {
+		if include != nil && !include(key) {
+			continue
+		}
+
+		value := json.RawMessage{}
+		if err := b.Get(ctx, key, &value); errors.Is(err, ErrNotFound) {
+			// Deleted or expired since it was listed
+			continue
+		} else if err != nil {
+			return 0, fmt.Errorf("unable to read %q: %w", key, err)
+		}
+
+		d.Values[key] = value
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(d); err != nil {
+		return 0, fmt.Errorf("unable to write export: %w", err)
+	}
+
+	return len(d.Values), nil
+}
+
+// Import loads a file produced by Export into b, overwriting any existing keys
+// of the same name. It returns the number of keys written.
+func Import(ctx context.Context, b Backend, r io.Reader) (int, error) {
+	d := dump{}
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return 0, fmt.Errorf("unable to read export: %w", err)
+	} else if d.Version != dumpVersion {
+		return 0, fmt.Errorf("unsupported export version %d", d.Version)
+	}
+
+	keys := make([]string, 0, len(d.Values))
+	for key := range d.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		if err := b.Set(ctx, key, d.Values[key], NoExpiry); err != nil {
+			return i, fmt.Errorf("unable to write %q: %w", key, err)
+		}
+	}
+
+	return len(keys), nil
+}
@@ -0,0 +1,82 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := NewVersioned(NewMemory(), &Migrations{})
+	src.Set(ctx, "rotator/1/rotation", map[string]int{"Current": 1}, NoExpiry)
+	src.Set(ctx, "rotator/2/rotation", map[string]int{"Current": 2}, NoExpiry)
+	src.Set(ctx, "rotator/3/rotation", map[string]int{"Current": 3}, NoExpiry)
+	src.Set(ctx, "client", "lil-dumpster", NoExpiry)
+
+	buf := bytes.Buffer{}
+	exported, err := Export(ctx, src, &buf, "rotator/", func(key string) bool {
+		return !strings.HasPrefix(key, "rotator/3/")
+	})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if exported != 2 {
+		t.Errorf("Export() = %d, want 2", exported)
+	}
+
+	dst := NewMemory()
+	imported, err := Import(ctx, dst, &buf)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if imported != 2 {
+		t.Errorf("Import() = %d, want 2", imported)
+	}
+
+	keys, _ := dst.List(ctx, "")
+	if want := []string{"rotator/1/rotation", "rotator/2/rotation"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("imported keys = %v, want %v", keys, want)
+	}
+
+	got := map[string]int{}
+	dst.Get(ctx, "rotator/2/rotation", &got)
+	if !reflect.DeepEqual(got, map[string]int{"Current": 2}) {
+		t.Errorf("imported value = %v", got)
+	}
+}
+
+func TestImport_invalid(t *testing.T) {
+	tests := map[string]string{
+		"not json":    "rotator",
+		"old version": `{"version":0,"values":{}}`,
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Import(context.Background(), NewMemory(), strings.NewReader(input)); err == nil {
+				t.Error("Import() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestExport_all(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemory()
+	src.Set(ctx, "a", 1, NoExpiry)
+	src.Set(ctx, "b", 2, NoExpiry)
+
+	buf := bytes.Buffer{}
+	if n, err := Export(ctx, src, &buf, "", nil); err != nil || n != 2 {
+		t.Fatalf("Export() = %d, %v, want 2", n, err)
+	}
+
+	d := dump{}
+	json.Unmarshal(buf.Bytes(), &d)
+	if len(d.Values) != 2 || d.Version != dumpVersion {
+		t.Errorf("Export() wrote %+v", d)
+	}
+}
@@ -0,0 +1,201 @@
+package state
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Encrypted is a Backend decorator that encrypts every value with AES-GCM
+// before it reaches the underlying Backend. Values are bound to their key, so
+// a ciphertext copied to another key will fail to decrypt.
+//
+// Several keys may be supplied to support key rotation: the first encrypts new
+// values while all of them are tried when decrypting. Values written before
+// encryption was enabled are read as plaintext and encrypted when next
+// written, or immediately by Rekey.
+type Encrypted struct {
+	Backend
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+type sealed struct {
+	KeyID string `json:"$encrypted"`
+	Data  []byte `json:"data"`
+}
+
+var errCurrent = errors.New("value already encrypted with the primary key")
+
+// NewEncrypted wraps b, encrypting with the first of keys. Each key must be 16,
+// 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+func NewEncrypted(b Backend, keys ...[]byte) (*Encrypted, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one encryption key is required")
+	}
+
+	s := &Encrypted{Backend: b, aeads: map[string]cipher.AEAD{}}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %d: %w", i+1, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %d: %w", i+1, err)
+		}
+
+		id := keyID(key)
+		if i == 0 {
+			s.primary = id
+		}
+		s.aeads[id] = aead
+	}
+
+	return s, nil
+}
+
+func (s *Encrypted) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := s.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.Backend.Set(ctx, key, data, ttl)
+}
+
+func (s *Encrypted) Get(ctx context.Context, key string, value interface{}) error {
+	raw := json.RawMessage{}
+	if err := s.Backend.Get(ctx, key, &raw); err != nil {
+		return err
+	}
+
+	data, _, err := s.open(key, raw)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+func (s *Encrypted) Update(ctx context.Context, key string, value interface{}, ttl time.Duration, fn func() error) error {
+	raw := json.RawMessage{}
+	return s.Backend.Update(ctx, key, &raw, ttl, func() error {
+		resetValue(value)
+		if raw != nil {
+			data, _, err := s.open(key, raw)
+			if err != nil {
+				return err
+			}
+
+			if err := json.Unmarshal(data, value); err != nil {
+				return err
+			}
+		}
+
+		if err := fn(); err != nil {
+			return err
+		}
+
+		var err error
+		raw, err = s.seal(key, value)
+		return err
+	})
+}
+
+// Rekey re-encrypts every value that is stored in plaintext or with a key
+// other than the primary one, preserving expiry times. Once it completes the
+// older keys may be retired. It returns the number of values rewritten.
+func (s *Encrypted) Rekey(ctx context.Context) (int, error) {
+	keys, err := s.List(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("unable to list keys: %w", err)
+	}
+
+	var rekeyed int
+	for _, key := range keys {
+		raw := json.RawMessage{}
+		err := s.Backend.Update(ctx, key, &raw, KeepTTL, func() error {
+			if raw == nil {
+				return ErrNotFound
+			}
+
+			data, current, err := s.open(key, raw)
+			if err != nil {
+				return err
+			} else if current {
+				return errCurrent
+			}
+
+			raw, err = s.seal(key, data)
+			return err
+		})
+
+		switch {
+		case errors.Is(err, errCurrent), errors.Is(err, ErrNotFound):
+			continue
+		case err != nil:
+			return rekeyed, fmt.Errorf("unable to re-encrypt %q: %w", key, err)
+		}
+
+		rekeyed++
+	}
+
+	return rekeyed, nil
+}
+
+func (s *Encrypted) seal(key string, value interface{}) (json.RawMessage, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	aead := s.aeads[s.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %w", err)
+	}
+
+	return json.Marshal(sealed{
+		KeyID: s.primary,
+		Data:  aead.Seal(nonce, nonce, plaintext, []byte(key)),
+	})
+}
+
+// open decrypts a stored value, reporting whether it was encrypted with the
+// primary key. Plaintext values are returned unchanged.
+func (s *Encrypted) open(key string, raw json.RawMessage) (json.RawMessage, bool, error) {
+	env := sealed{}
+	if err := json.Unmarshal(raw, &env); err != nil || env.KeyID == "" {
+		return raw, false, nil
+	}
+
+	aead, ok := s.aeads[env.KeyID]
+	if !ok {
+		return nil, false, fmt.Errorf("%q is encrypted with unknown key %s", key, env.KeyID)
+	} else if len(env.Data) < aead.NonceSize() {
+		return nil, false, fmt.Errorf("%q has a truncated ciphertext", key)
+	}
+
+	nonce, ciphertext := env.Data[:aead.NonceSize()], env.Data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to decrypt %q: %w", key, err)
+	}
+
+	return plaintext, env.KeyID == s.primary, nil
+}
+
+// keyID is a short, non-reversible identifier for an encryption key.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,89 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestEncrypted_storesCiphertext(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemory()
+	s, _ := NewEncrypted(inner, newKey)
+
+	s.Set(ctx, "secret", "user 123456789", NoExpiry)
+
+	raw := json.RawMessage{}
+	inner.Get(ctx, "secret", &raw)
+	if strings.Contains(string(raw), "123456789") {
+		t.Errorf("stored value %s contains plaintext", raw)
+	}
+
+	// Ciphertexts are bound to their key
+	inner.Set(ctx, "moved", raw, NoExpiry)
+	if err := s.Get(ctx, "moved", new(string)); err == nil {
+		t.Error("Encrypted.Get() of a ciphertext moved between keys succeeded")
+	}
+
+	// Other keys can't read it
+	other, _ := NewEncrypted(inner, oldKey)
+	if err := other.Get(ctx, "secret", new(string)); err == nil {
+		t.Error("Encrypted.Get() with an unknown key succeeded")
+	}
+}
+
+func TestEncrypted_Rekey(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemory()
+	inner.Set(ctx, "plaintext", "legacy", NoExpiry)
+
+	old, _ := NewEncrypted(inner, oldKey)
+	old.Set(ctx, "old", "old value", NoExpiry)
+
+	s, err := NewEncrypted(inner, newKey, oldKey)
+	if err != nil {
+		t.Fatalf("NewEncrypted() error = %v", err)
+	}
+	s.Set(ctx, "new", "new value", NoExpiry)
+
+	// Every generation of value is readable while the old key is configured
+	for key, want := range map[string]string{"plaintext": "legacy", "old": "old value", "new": "new value"} {
+		got := ""
+		if err := s.Get(ctx, key, &got); err != nil || got != want {
+			t.Errorf("Encrypted.Get(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+
+	rekeyed, err := s.Rekey(ctx)
+	if err != nil {
+		t.Fatalf("Encrypted.Rekey() error = %v", err)
+	}
+	if rekeyed != 2 {
+		t.Errorf("Encrypted.Rekey() = %d, want 2", rekeyed)
+	}
+
+	// After rekeying the old key may be retired
+	retired, _ := NewEncrypted(inner, newKey)
+	for key, want := range map[string]string{"plaintext": "legacy", "old": "old value", "new": "new value"} {
+		got := ""
+		if err := retired.Get(ctx, key, &got); err != nil || got != want {
+			t.Errorf("Encrypted.Get(%q) after Rekey() = %q, %v, want %q", key, got, err, want)
+		}
+	}
+}
+
+func TestNewEncrypted_invalid(t *testing.T) {
+	if _, err := NewEncrypted(NewMemory()); err == nil {
+		t.Error("NewEncrypted() with no keys succeeded")
+	}
+	if _, err := NewEncrypted(NewMemory(), []byte("short")); err == nil {
+		t.Error("NewEncrypted() with a short key succeeded")
+	}
+}
@@ -0,0 +1,278 @@
+package state
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is a Backend that keeps its data in memory and persists every change to
+// an append-only JSON log on disk. The log is replayed on startup and compacted
+// down to the live keys once it has grown well beyond them.
+type File struct {
+	*Memory
+	path    string
+	log     logFile
+	records int
+}
+
+// logFile is the subset of *os.File that File appends to.
+type logFile interface {
+	io.WriteCloser
+	Sync() error
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+}
+
+type fileRecord struct {
+	Op      string          `json:"op"`
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Expires *time.Time      `json:"expires,omitempty"`
+}
+
+const (
+	fileOpSet    = "set"
+	fileOpDelete = "del"
+
+	// compactThreshold is the number of stale records tolerated in the log before
+	// it gets rewritten.
+	compactThreshold = 1000
+)
+
+func NewFile(path string) (*File, error) {
+	s := &File{Memory: NewMemory(), path: path}
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("unable to load %s: %w", path, err)
+	}
+
+	// Always start from a compacted log so that replay stays cheap
+	if err := s.compact(); err != nil {
+		return nil, fmt.Errorf("unable to compact %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *File) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.set(key, value, ttl)
+}
+
+func (s *File) Update(ctx context.Context, key string, value interface{}, ttl time.Duration, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resetValue(value)
+	if err := s.get(key, value); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	return s.set(key, value, ttl)
+}
+
+// set logs and then applies a change, so that a failed write leaves the value
+// readable in memory as it will be after a restart. It must be called with the
+// lock held.
+func (s *File) set(key string, value interface{}, ttl time.Duration) error {
+	entry, err := s.newEntry(key, value, ttl)
+	if err != nil {
+		return err
+	}
+
+	if err := s.append(newSetRecord(key, entry)); err != nil {
+		return err
+	}
+	s.store(key, entry)
+
+	return s.compactIfStale()
+}
+
+func (s *File) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+
+	if err := s.append(fileRecord{Op: fileOpDelete, Key: key}); err != nil {
+		return err
+	}
+	s.delete(key)
+
+	return s.compactIfStale()
+}
+
+// Close flushes and closes the underlying log file.
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.Close()
+}
+
+// load replays the log into memory. A partially written final record, left
+// behind by a crash mid-write, is discarded.
+func (s *File) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		rec := fileRecord{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("corrupt record %q: %w", line, err)
+		}
+
+		switch rec.Op {
+		case fileOpSet:
+			entry := memoryEntry{value: rec.Value}
+			if rec.Expires != nil {
+				entry.expires = *rec.Expires
+			}
+			s.data[rec.Key] = entry
+		case fileOpDelete:
+			delete(s.data, rec.Key)
+		default:
+			return fmt.Errorf("unknown record operation %q", rec.Op)
+		}
+	}
+}
+
+// append writes a record to the log and syncs it. If either fails the log is
+// cut back to where it was, so that a partly written record doesn't corrupt
+// the next one and a record that might not be durable doesn't reappear on the
+// next start.
+func (s *File) append(rec fileRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.log.Write(append(line, '\n')); err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		return s.rollback(info.Size(), err)
+	}
+
+	s.records++
+	return nil
+}
+
+// rollback truncates the log to size after a failed append, falling back to
+// rewriting it from memory, which the record hasn't been applied to yet.
+func (s *File) rollback(size int64, err error) error {
+	if truncErr := s.log.Truncate(size); truncErr != nil {
+		if compactErr := s.compact(); compactErr != nil {
+			return fmt.Errorf("%w (and the log could not be repaired: %s)", err, compactErr)
+		}
+	}
+
+	return err
+}
+
+// compactIfStale compacts the log once it holds too many stale records. It is
+// called after a change has been applied in memory, as compaction rewrites the
+// log from memory.
+func (s *File) compactIfStale() error {
+	if s.records > len(s.data)+compactThreshold {
+		return s.compact()
+	}
+
+	return nil
+}
+
+func newSetRecord(key string, entry memoryEntry) fileRecord {
+	rec := fileRecord{Op: fileOpSet, Key: key, Value: entry.value}
+	if !entry.expires.IsZero() {
+		rec.Expires = &entry.expires
+	}
+
+	return rec
+}
+
+// compact atomically replaces the log with one record per live key.
+func (s *File) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for key, entry := range s.data {
+		if entry.expired() {
+			delete(s.data, key)
+			continue
+		}
+
+		line, err := json.Marshal(newSetRecord(key, entry))
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+
+		w.Write(append(line, '\n'))
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+
+	// A failed open leaves a nil *os.File, whose methods all return errors
+	log, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	s.log = log
+	if err != nil {
+		return err
+	}
+
+	s.records = len(s.data)
+	return nil
+}
@@ -0,0 +1,181 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile_reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.log")
+
+	s, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+	s.Set(ctx, "kept", "value", NoExpiry)
+	s.Set(ctx, "deleted", "value", NoExpiry)
+	s.Set(ctx, "expired", "value", time.Millisecond)
+	s.Delete(ctx, "deleted")
+	s.Update(ctx, "updated", new(int), NoExpiry, func() error { return nil })
+	s.Close()
+	time.Sleep(time.Millisecond)
+
+	// Simulate a crash partway through writing a record
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	f.WriteString(`{"op":"set","key":"partial","val`)
+	f.Close()
+
+	s, err = NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile() reopen error = %v", err)
+	}
+	defer s.Close()
+
+	got := ""
+	if err := s.Get(ctx, "kept", &got); err != nil || got != "value" {
+		t.Errorf("File.Get() = %q, %v, want %q", got, err, "value")
+	}
+
+	keys, _ := s.List(ctx, "")
+	if want := []string{"kept", "updated"}; len(keys) != len(want) || keys[0] != want[0] || keys[1] != want[1] {
+		t.Errorf("File.List() = %v, want %v", keys, want)
+	}
+}
+
+func TestFile_compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.log")
+
+	s, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < compactThreshold*2; i++ {
+		if err := s.Set(ctx, "key", i, NoExpiry); err != nil {
+			t.Fatalf("File.Set() error = %v", err)
+		}
+	}
+
+	if s.records > compactThreshold+1 {
+		t.Errorf("File.records = %d, want at most %d", s.records, compactThreshold+1)
+	}
+
+	got := 0
+	if err := s.Get(ctx, "key", &got); err != nil || got != compactThreshold*2-1 {
+		t.Errorf("File.Get() = %d, %v, want %d", got, err, compactThreshold*2-1)
+	}
+}
+
+func TestFile_failedWrite(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := NewFile(filepath.Join(t.TempDir(), "state.log"))
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+	if err := s.Set(ctx, "key", "old", NoExpiry); err != nil {
+		t.Fatalf("File.Set() error = %v", err)
+	}
+
+	// Every append fails once the log has been closed
+	s.Close()
+
+	if err := s.Set(ctx, "key", "set", NoExpiry); err == nil {
+		t.Error("File.Set() error = nil, want the write error")
+	}
+	updated := ""
+	if err := s.Update(ctx, "key", &updated, NoExpiry, func() error { updated = "updated"; return nil }); err == nil {
+		t.Error("File.Update() error = nil, want the write error")
+	}
+	if err := s.Delete(ctx, "key"); err == nil {
+		t.Error("File.Delete() error = nil, want the write error")
+	}
+
+	// Nothing that failed to reach the log is visible in memory
+	got := ""
+	if err := s.Get(ctx, "key", &got); err != nil || got != "old" {
+		t.Errorf("File.Get() = %q, %v, want %q", got, err, "old")
+	}
+}
+
+// faultyLog fails appends to a real log, after writing part of the record or
+// when syncing it.
+type faultyLog struct {
+	*os.File
+	tear     bool
+	failSync bool
+}
+
+func (f *faultyLog) Write(p []byte) (int, error) {
+	if f.tear {
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errors.New("no space left on device")
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultyLog) Sync() error {
+	if f.failSync {
+		return errors.New("sync failed")
+	}
+	return f.File.Sync()
+}
+
+func TestFile_failedAppend(t *testing.T) {
+	tests := []struct {
+		name string
+		log  faultyLog
+	}{
+		{name: "torn write", log: faultyLog{tear: true}},
+		{name: "failed sync", log: faultyLog{failSync: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "state.log")
+
+			s, err := NewFile(path)
+			if err != nil {
+				t.Fatalf("NewFile() error = %v", err)
+			}
+			if err := s.Set(ctx, "key", "old", NoExpiry); err != nil {
+				t.Fatalf("File.Set() error = %v", err)
+			}
+
+			healthy := s.log.(*os.File)
+			faulty := tt.log
+			faulty.File = healthy
+			s.log = &faulty
+			if err := s.Set(ctx, "key", "lost", NoExpiry); err == nil {
+				t.Fatal("File.Set() error = nil, want the write error")
+			}
+
+			// Appends after the failure must not run into what it left behind
+			s.log = healthy
+			if err := s.Set(ctx, "other", "kept", NoExpiry); err != nil {
+				t.Fatalf("File.Set() error = %v", err)
+			}
+			s.Close()
+
+			s, err = NewFile(path)
+			if err != nil {
+				t.Fatalf("NewFile() after a failed append error = %v", err)
+			}
+			defer s.Close()
+
+			for key, want := range map[string]string{"key": "old", "other": "kept"} {
+				got := ""
+				if err := s.Get(ctx, key, &got); err != nil || got != want {
+					t.Errorf("File.Get(%q) = %q, %v, want %q", key, got, err, want)
+				}
+			}
+		})
+	}
+}
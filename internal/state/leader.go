@@ -0,0 +1,160 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Leader campaigns for a lease stored in a Backend so that only one of the
+// processes sharing the Backend considers itself the leader at a time. The
+// lease expires on its own if the leader stops renewing it, allowing another
+// process to take over.
+type Leader struct {
+	store Backend
+	key   string
+	id    string
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	leading bool
+}
+
+type lease struct {
+	Holder string
+}
+
+var errLeaseHeld = errors.New("lease held by another process")
+
+func NewLeader(store Backend, key string, ttl time.Duration) *Leader {
+	return &Leader{store: store, key: key, id: newLeaderID(), ttl: ttl}
+}
+
+// ID identifies this process in the lease.
+func (l *Leader) ID() string {
+	return l.id
+}
+
+// IsLeader reports whether this process currently holds the lease.
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.leading
+}
+
+// Run campaigns for the lease until ctx is done, renewing it while held. Each
+// time leadership is gained onElected is started in a new goroutine with a
+// context that is cancelled as soon as leadership is lost.
+func (l *Leader) Run(ctx context.Context, onElected func(ctx context.Context)) {
+	var cancelTerm context.CancelFunc = func() {}
+	defer func() {
+		cancelTerm()
+		l.release()
+	}()
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := l.acquire(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Unable to renew leadership lease %q: %s", l.key, err)
+		}
+
+		wasLeading := l.setLeading(acquired)
+		if acquired && !wasLeading {
+			log.Printf("Elected leader for %q as %s", l.key, l.id)
+
+			cancelTerm = l.startTerm(ctx, onElected)
+		} else if !acquired && wasLeading {
+			log.Printf("Lost leadership for %q", l.key)
+			cancelTerm()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startTerm runs onElected with a context that lasts until the returned cancel
+// function is called.
+func (l *Leader) startTerm(ctx context.Context, onElected func(ctx context.Context)) context.CancelFunc {
+	termCtx, cancel := context.WithCancel(ctx)
+	go onElected(termCtx)
+	return cancel
+}
+
+// acquire takes the lease if it is free or renews it if already held.
+func (l *Leader) acquire(ctx context.Context) (bool, error) {
+	data := lease{}
+	err := l.store.Update(ctx, l.key, &data, l.ttl, func() error {
+		if data.Holder != "" && data.Holder != l.id {
+			return errLeaseHeld
+		}
+
+		data.Holder = l.id
+		return nil
+	})
+
+	if errors.Is(err, errLeaseHeld) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release gives up the lease, if held, so that another process may take over
+// without waiting for it to expire.
+func (l *Leader) release() {
+	if !l.setLeading(false) {
+		return
+	}
+
+	// The campaign context is already done, so allow a brief window to clean up
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// Clear the holder in the same update that checks it, as another process
+	// may have taken over an expired lease in the meantime. The empty lease is
+	// free to acquire, and expires when the held one would have.
+	data := lease{}
+	err := l.store.Update(ctx, l.key, &data, KeepTTL, func() error {
+		if data.Holder != l.id {
+			return errLeaseHeld
+		}
+
+		data.Holder = ""
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLeaseHeld) {
+		log.Printf("Unable to release leadership lease %q: %s", l.key, err)
+	}
+}
+
+func (l *Leader) setLeading(leading bool) (was bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	was, l.leading = l.leading, leading
+	return was
+}
+
+func newLeaderID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", host, hex.EncodeToString(suffix))
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeader(t *testing.T) {
+	const ttl = 30 * time.Millisecond
+
+	store := NewMemory()
+	first := NewLeader(store, "leader", ttl)
+	second := NewLeader(store, "leader", ttl)
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstElected := make(chan context.Context, 1)
+	firstDone := make(chan struct{})
+	go func() {
+		first.Run(firstCtx, func(ctx context.Context) { firstElected <- ctx })
+		close(firstDone)
+	}()
+
+	var term context.Context
+	select {
+	case term = <-firstElected:
+	case <-time.After(time.Second):
+		t.Fatal("first Leader.Run() was never elected")
+	}
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	secondElected := make(chan struct{}, 1)
+	go second.Run(secondCtx, func(ctx context.Context) { secondElected <- struct{}{} })
+
+	// The second campaign must not win while the first keeps renewing
+	time.Sleep(ttl * 3)
+	if !first.IsLeader() || second.IsLeader() {
+		t.Fatalf("IsLeader() first = %v, second = %v, want true, false", first.IsLeader(), second.IsLeader())
+	}
+
+	// Once the first steps down the second takes over
+	stopFirst()
+	<-firstDone
+	if term.Err() == nil {
+		t.Error("Leader.Run() did not cancel the term context after stepping down")
+	}
+	if first.IsLeader() {
+		t.Error("first IsLeader() = true after stepping down")
+	}
+
+	select {
+	case <-secondElected:
+	case <-time.After(time.Second):
+		t.Fatal("second Leader.Run() was never elected")
+	}
+	if !second.IsLeader() {
+		t.Error("second IsLeader() = false after election")
+	}
+}
+
+func TestLeader_release(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// takeover replaces the lease before it is released, as another process
+		// would once it expired
+		takeover   bool
+		wantHolder string
+	}{
+		{name: "held", wantHolder: ""},
+		{name: "taken over", takeover: true, wantHolder: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemory()
+			l := NewLeader(store, "leader", time.Minute)
+			if ok, err := l.acquire(ctx); !ok || err != nil {
+				t.Fatalf("acquire() = %v, %v", ok, err)
+			}
+			l.setLeading(true)
+
+			if tt.takeover {
+				store.Set(ctx, "leader", lease{Holder: "other"}, time.Minute)
+			}
+			l.release()
+
+			got := lease{}
+			if err := store.Get(ctx, "leader", &got); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got.Holder != tt.wantHolder {
+				t.Errorf("lease holder = %q after release, want %q", got.Holder, tt.wantHolder)
+			}
+
+			// A released lease is free for the next process
+			next := NewLeader(store, "leader", time.Minute)
+			if ok, _ := next.acquire(ctx); ok != !tt.takeover {
+				t.Errorf("acquire() after release = %v, want %v", ok, !tt.takeover)
+			}
+		})
+	}
+}
@@ -4,29 +4,177 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
-type Memory map[string][]byte
+type Memory struct {
+	mu       sync.Mutex
+	data     map[string]memoryEntry
+	watchers map[*memoryWatcher]struct{}
+}
+
+type memoryWatcher struct {
+	prefix string
+	events chan Event
+}
 
-var memoryMutext = sync.Mutex{}
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
 
 func NewMemory() *Memory {
-	return &Memory{}
+	return &Memory{data: map[string]memoryEntry{}, watchers: map[*memoryWatcher]struct{}{}}
+}
+
+func (s *Memory) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.set(key, value, ttl)
+}
+
+func (s *Memory) Get(ctx context.Context, key string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.get(key, value)
+}
+
+func (s *Memory) Update(ctx context.Context, key string, value interface{}, ttl time.Duration, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resetValue(value)
+	if err := s.get(key, value); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	return s.set(key, value, ttl)
+}
+
+func (s *Memory) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delete(key)
+	return nil
+}
+
+func (s *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := []string{}
+	for key, entry := range s.data {
+		if entry.expired() {
+			delete(s.data, key)
+		} else if strings.HasPrefix(key, prefix) {
+			ret = append(ret, key)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret, nil
 }
 
-func (s Memory) Set(ctx context.Context, key string, value interface{}) (err error) {
-	memoryMutext.Lock()
-	defer memoryMutext.Unlock()
+func (s *Memory) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	s[key], err = json.Marshal(value)
-	return
+	w := &memoryWatcher{prefix: prefix, events: make(chan Event, watchBuffer)}
+	s.watchers[w] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, w)
+		close(w.events)
+	}()
+
+	return w.events, nil
+}
+
+func (s *Memory) set(key string, value interface{}, ttl time.Duration) error {
+	entry, err := s.newEntry(key, value, ttl)
+	if err != nil {
+		return err
+	}
+
+	s.store(key, entry)
+	return nil
 }
 
-func (s Memory) Get(ctx context.Context, key string, value interface{}) error {
-	if data, ok := s[key]; !ok {
-		return errors.New("key not found")
-	} else {
-		return json.Unmarshal(data, value)
+// newEntry encodes a value to be stored under key, without storing it. It must
+// be called with the lock held.
+func (s *Memory) newEntry(key string, value interface{}, ttl time.Duration) (memoryEntry, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return memoryEntry{}, err
 	}
+
+	entry := memoryEntry{value: data}
+	if ttl == KeepTTL {
+		if existing, ok := s.data[key]; ok && !existing.expired() {
+			entry.expires = existing.expires
+		}
+	} else if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	return entry, nil
+}
+
+func (s *Memory) store(key string, entry memoryEntry) {
+	s.data[key] = entry
+	s.notify(Event{Key: key})
+}
+
+func (s *Memory) delete(key string) {
+	if _, ok := s.data[key]; ok {
+		delete(s.data, key)
+		s.notify(Event{Key: key, Deleted: true})
+	}
+}
+
+// notify sends the event to every interested watcher without blocking. It must
+// be called with the lock held.
+func (s *Memory) notify(e Event) {
+	for w := range s.watchers {
+		if !strings.HasPrefix(e.Key, w.prefix) {
+			continue
+		}
+
+		select {
+		case w.events <- e:
+		default:
+			log.Printf("Dropping state event for %q: watcher is not keeping up", e.Key)
+		}
+	}
+}
+
+func (s *Memory) get(key string, value interface{}) error {
+	entry, ok := s.data[key]
+	if !ok {
+		return ErrNotFound
+	} else if entry.expired() {
+		delete(s.data, key)
+		return ErrNotFound
+	}
+
+	return json.Unmarshal(entry.value, value)
+}
+
+func (e memoryEntry) expired() bool {
+	return !e.expires.IsZero() && !time.Now().Before(e.expires)
 }
@@ -0,0 +1,69 @@
+package state
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Prefixed is a Backend decorator that confines all keys beneath a prefix, so
+// that callers may use short keys without colliding with one another.
+type Prefixed struct {
+	b      Backend
+	prefix string
+}
+
+func NewPrefixed(b Backend, prefix string) *Prefixed {
+	return &Prefixed{b: b, prefix: prefix}
+}
+
+func (s *Prefixed) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return s.b.Set(ctx, s.prefix+key, value, ttl)
+}
+
+func (s *Prefixed) Get(ctx context.Context, key string, value interface{}) error {
+	return s.b.Get(ctx, s.prefix+key, value)
+}
+
+func (s *Prefixed) Update(ctx context.Context, key string, value interface{}, ttl time.Duration, fn func() error) error {
+	return s.b.Update(ctx, s.prefix+key, value, ttl, fn)
+}
+
+func (s *Prefixed) Delete(ctx context.Context, key string) error {
+	return s.b.Delete(ctx, s.prefix+key)
+}
+
+func (s *Prefixed) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.b.List(ctx, s.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], s.prefix)
+	}
+
+	return keys, nil
+}
+
+func (s *Prefixed) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	events, err := s.b.Watch(ctx, s.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(chan Event, watchBuffer)
+	go func() {
+		defer close(ret)
+		for e := range events {
+			e.Key = strings.TrimPrefix(e.Key, s.prefix)
+			select {
+			case ret <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ret, nil
+}
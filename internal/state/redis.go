@@ -3,6 +3,12 @@ package state
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,30 +16,226 @@ import (
 )
 
 type Redis struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
-var redisMutext = sync.Mutex{}
+const (
+	// maxUpdateAttempts bounds how many times Update retries when another writer
+	// modifies the key between the read and the write.
+	maxUpdateAttempts = 10
+
+	// updateBackoff is the base interval between Update retries.
+	updateBackoff = time.Millisecond
+
+	// eventsChannel is the pub/sub channel on which every change is announced
+	// to the watchers in all processes sharing the server. Changes are
+	// published once their write has succeeded rather than in the same
+	// transaction, which Cluster can't guarantee across the key and the
+	// channel, so an event is lost if publishing fails or the process stops
+	// in between.
+	eventsChannel = "lil-dumpster:events"
+)
 
 func NewRedis(opt *redis.Options) *Redis {
-	return &Redis{client: redis.NewClient(opt)}
+	return NewRedisClient(redis.NewClient(opt))
 }
 
-func (s Redis) Set(ctx context.Context, key string, value interface{}) (err error) {
-	redisMutext.Lock()
-	defer redisMutext.Unlock()
+// NewRedisClient wraps an existing client, which may be a single node,
+// Sentinel failover or Cluster client.
+func NewRedisClient(client redis.UniversalClient) *Redis {
+	return &Redis{client: client}
+}
 
+func (s Redis) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) (err error) {
 	data, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
 
-	return s.client.Set(ctx, key, data, time.Hour*24*90).Err()
+	if err := s.client.Set(ctx, key, data, redisTTL(ttl)).Err(); err != nil {
+		return err
+	}
+
+	s.publish(ctx, Event{Key: key})
+	return nil
 }
 
 func (s Redis) Get(ctx context.Context, key string, value interface{}) error {
-	cmd := s.client.Get(ctx, key)
-	if cmd.Err() != nil {
+	return get(ctx, s.client, key, value)
+}
+
+func (s Redis) Update(ctx context.Context, key string, value interface{}, ttl time.Duration, fn func() error) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			// Start each attempt from a clean value so a retry doesn't see
+			// fields left over from the losing read
+			resetValue(value)
+
+			if err := get(ctx, tx, key, value); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+
+			if err := fn(); err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return pipe.Set(ctx, key, data, redisTTL(ttl)).Err()
+			})
+			return err
+		}, key)
+
+		if err == nil {
+			s.publish(ctx, Event{Key: key})
+			return nil
+		} else if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+
+		// Back off for a random interval so that competing writers spread out
+		// rather than colliding again on the next attempt
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(rand.Int63n(int64(updateBackoff) << attempt))):
+		}
+	}
+
+	return fmt.Errorf("unable to update %q: too many concurrent writers", key)
+}
+
+func (s Redis) Delete(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, key).Err(); err != nil {
+		return err
+	}
+
+	s.publish(ctx, Event{Key: key, Deleted: true})
+	return nil
+}
+
+func (s Redis) List(ctx context.Context, prefix string) ([]string, error) {
+	var mu sync.Mutex
+	ret := []string{}
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, globEscaper.Replace(prefix)+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			ret = append(ret, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+
+	// Cluster keys are spread across the masters, which each need scanning
+	var err error
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+	} else {
+		err = scan(ctx, s.client)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// SCAN may return a key more than once, and in no particular order
+	sort.Strings(ret)
+	return compact(ret), nil
+}
+
+func (s Redis) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	sub := s.client.Subscribe(ctx, eventsChannel)
+
+	// Wait for the subscription to be confirmed so that no events are missed
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, fmt.Errorf("unable to subscribe to events: %w", err)
+	}
+
+	ret := make(chan Event, watchBuffer)
+	go func() {
+		defer close(ret)
+		defer sub.Close()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				e := Event{}
+				if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+					log.Printf("Ignoring malformed state event %q: %s", msg.Payload, err)
+					continue
+				} else if !strings.HasPrefix(e.Key, prefix) {
+					continue
+				}
+
+				select {
+				case ret <- e:
+				default:
+					log.Printf("Dropping state event for %q: watcher is not keeping up", e.Key)
+				}
+			}
+		}
+	}()
+
+	return ret, nil
+}
+
+// publish announces a change that has already been written. A failure is only
+// logged, as the write itself succeeded and watchers tolerate missed events.
+func (s Redis) publish(ctx context.Context, e Event) {
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = s.client.Publish(ctx, eventsChannel, data).Err()
+	}
+	if err != nil {
+		log.Printf("Unable to announce change to %q: %s", e.Key, err)
+	}
+}
+
+// globEscaper escapes the characters that SCAN's MATCH treats as patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func compact(sorted []string) []string {
+	ret := sorted[:0]
+	for i, key := range sorted {
+		if i == 0 || key != sorted[i-1] {
+			ret = append(ret, key)
+		}
+	}
+
+	return ret
+}
+
+// redisTTL maps the Backend TTL conventions onto go-redis expirations.
+func redisTTL(ttl time.Duration) time.Duration {
+	switch {
+	case ttl == KeepTTL:
+		return redis.KeepTTL
+	case ttl <= 0:
+		return 0
+	default:
+		return ttl
+	}
+}
+
+func get(ctx context.Context, client redis.Cmdable, key string, value interface{}) error {
+	cmd := client.Get(ctx, key)
+	if errors.Is(cmd.Err(), redis.Nil) {
+		return ErrNotFound
+	} else if cmd.Err() != nil {
 		return cmd.Err()
 	}
 
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedis_List(t *testing.T) {
+	ctx := context.Background()
+	s := NewRedis(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	keys := []string{"a*/1", "ab/1", "a?/1", "ax/1", "a[b]/1", "ab]/1", `a\/1`, "a/1"}
+	for _, key := range keys {
+		if err := s.Set(ctx, key, true, NoExpiry); err != nil {
+			t.Fatalf("Set(%q) error = %v", key, err)
+		}
+	}
+
+	// Each prefix would match more keys if used as a SCAN pattern unescaped
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "a*", want: []string{"a*/1"}},
+		{prefix: "a?", want: []string{"a?/1"}},
+		{prefix: "a[b]", want: []string{"a[b]/1"}},
+		{prefix: `a\`, want: []string{`a\/1`}},
+		{prefix: "a", want: []string{"a*/1", "a/1", "a?/1", "a[b]/1", `a\/1`, "ab/1", "ab]/1", "ax/1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := s.List(ctx, tt.prefix)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedis_Delete(t *testing.T) {
+	ctx := context.Background()
+	s := NewRedis(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	for _, key := range []string{"a*", "ab"} {
+		if err := s.Set(ctx, key, true, NoExpiry); err != nil {
+			t.Fatalf("Set(%q) error = %v", key, err)
+		}
+	}
+
+	// Only the exact key is deleted, and missing keys aren't an error
+	if err := s.Delete(ctx, "a*"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := s.Delete(ctx, "missing"); err != nil {
+		t.Fatalf("Delete() of a missing key error = %v", err)
+	}
+
+	if err := s.Get(ctx, "a*", new(bool)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Get(ctx, "ab", new(bool)); err != nil {
+		t.Errorf("Get() of another key error = %v", err)
+	}
+}
@@ -1,8 +1,63 @@
 package state
 
-import "context"
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// ErrNotFound is returned by every Backend when the requested key does not exist.
+var ErrNotFound = errors.New("key not found")
+
+const (
+	// NoExpiry keeps the key until it is explicitly deleted.
+	NoExpiry time.Duration = 0
+
+	// KeepTTL leaves the key's existing expiry untouched. Keys that don't exist
+	// yet are written without an expiry.
+	KeepTTL time.Duration = -1
+)
 
 type Backend interface {
-	Set(ctx context.Context, key string, value interface{}) (err error)
+	// Set writes the key, expiring it after ttl. Use NoExpiry to keep it forever.
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) (err error)
 	Get(ctx context.Context, key string, value interface{}) error
+
+	// Update atomically reads the key into value, calls fn to modify it, then
+	// writes value back with the given ttl. If the key does not exist fn sees
+	// the zero value. Returning an error from fn aborts the write and is
+	// returned to the caller.
+	Update(ctx context.Context, key string, value interface{}, ttl time.Duration, fn func() error) error
+
+	// Delete removes the key. Deleting a key that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List returns every key beginning with prefix, in sorted order.
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// Watch streams writes and deletions of keys beginning with prefix,
+	// including those made by other processes sharing the backend, until ctx
+	// is done. Delivery is best effort: events are dropped if the receiver
+	// falls too far behind or, for shared backends, if announcing a change
+	// fails after it was written. Expirations are not reported.
+	Watch(ctx context.Context, prefix string) (<-chan Event, error)
+}
+
+// Event describes a change to a single key.
+type Event struct {
+	Key     string `json:"key"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// watchBuffer is the number of events buffered for each watcher.
+const watchBuffer = 100
+
+// resetValue zeroes the value pointed to by value so that a fresh read doesn't
+// inherit fields from an earlier one.
+func resetValue(value interface{}) {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
 }
@@ -0,0 +1,353 @@
+// Package statetest provides a conformance suite that every state.Backend
+// implementation is expected to pass.
+package statetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+// Harness describes how to exercise a single Backend implementation.
+type Harness struct {
+	// New returns an empty Backend for a single test.
+	New func(t *testing.T) state.Backend
+
+	// Elapse moves the Backend's clock forward by d. Backends that expire keys
+	// on wall clock time may leave this nil, in which case the suite sleeps.
+	Elapse func(d time.Duration)
+}
+
+type record struct {
+	Name    string
+	Count   int
+	Tags    []string
+	Nested  map[string]int
+	Pointer *string
+}
+
+// Run executes the conformance suite against the Backend described by h.
+func Run(t *testing.T, h Harness) {
+	if h.Elapse == nil {
+		h.Elapse = time.Sleep
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, h) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, h) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, h) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, h) })
+	t.Run("ConcurrentUpdates", func(t *testing.T) { testConcurrentUpdates(t, h) })
+	t.Run("JSON", func(t *testing.T) { testJSON(t, h) })
+	t.Run("ListDelete", func(t *testing.T) { testListDelete(t, h) })
+	t.Run("Expiry", func(t *testing.T) { testExpiry(t, h) })
+	t.Run("Watch", func(t *testing.T) { testWatch(t, h) })
+}
+
+func testRoundTrip(t *testing.T, h Harness) {
+	ctx := context.Background()
+	s := h.New(t)
+
+	pointer := "pointed"
+	want := record{
+		Name:    "rotation",
+		Count:   3,
+		Tags:    []string{"a", "b"},
+		Nested:  map[string]int{"x": 1},
+		Pointer: &pointer,
+	}
+	if err := s.Set(ctx, "key", want, state.NoExpiry); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	got := record{}
+	if err := s.Get(ctx, "key", &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+}
+
+func testNotFound(t *testing.T, h Harness) {
+	ctx := context.Background()
+	s := h.New(t)
+
+	got := record{}
+	if err := s.Get(ctx, "missing", &got); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, state.ErrNotFound)
+	}
+
+	if err := s.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete() error = %v, want nil", err)
+	}
+
+	got = record{Name: "stale"}
+	err := s.Update(ctx, "missing", &got, state.NoExpiry, func() error {
+		if !reflect.DeepEqual(got, record{}) {
+			t.Errorf("Update() value = %+v, want zero value", got)
+		}
+		got.Name = "created"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	got = record{}
+	if err := s.Get(ctx, "missing", &got); err != nil || got.Name != "created" {
+		t.Errorf("Get() = %+v, %v, want created record", got, err)
+	}
+}
+
+func testOverwrite(t *testing.T, h Harness) {
+	ctx := context.Background()
+	s := h.New(t)
+
+	s.Set(ctx, "key", record{Name: "first", Tags: []string{"a"}}, state.NoExpiry)
+	s.Set(ctx, "key", record{Name: "second"}, state.NoExpiry)
+
+	got := record{}
+	if err := s.Get(ctx, "key", &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if want := (record{Name: "second"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+}
+
+func testUpdate(t *testing.T, h Harness) {
+	ctx := context.Background()
+	s := h.New(t)
+
+	s.Set(ctx, "key", record{Name: "original", Count: 1}, state.NoExpiry)
+
+	abort := errors.New("abort")
+	got := record{}
+	err := s.Update(ctx, "key", &got, state.NoExpiry, func() error {
+		got.Name = "modified"
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Errorf("Update() error = %v, want %v", err, abort)
+	}
+
+	got = record{}
+	if s.Get(ctx, "key", &got); got.Name != "original" {
+		t.Errorf("Get() after aborted Update() = %+v, want original", got)
+	}
+
+	err = s.Update(ctx, "key", &got, state.NoExpiry, func() error {
+		got.Count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	got = record{}
+	if s.Get(ctx, "key", &got); got.Count != 2 {
+		t.Errorf("Get() after Update() Count = %d, want 2", got.Count)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, h Harness) {
+	const writers = 10
+	const increments = 5
+
+	ctx := context.Background()
+	s := h.New(t)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				counter := 0
+				err := s.Update(ctx, "counter", &counter, state.NoExpiry, func() error {
+					counter++
+					return nil
+				})
+				if err != nil {
+					t.Errorf("Update() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := 0
+	if err := s.Get(ctx, "counter", &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got != writers*increments {
+		t.Errorf("Get() = %d, want %d", got, writers*increments)
+	}
+}
+
+func testJSON(t *testing.T, h Harness) {
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		into  func() interface{}
+	}{
+		{name: "empty string", key: "empty", value: "", into: func() interface{} { return new(string) }},
+		{name: "unicode", key: "ключ/🔑", value: "🗑️🔥 <&> \"quoted\"", into: func() interface{} { return new(string) }},
+		{name: "newlines", key: "newlines", value: "line1\nline2\r\n", into: func() interface{} { return new(string) }},
+		{name: "large int", key: "int", value: int64(1<<53 - 1), into: func() interface{} { return new(int64) }},
+		{name: "nil slice", key: "nil", value: []string(nil), into: func() interface{} { return new([]string) }},
+		{name: "large value", key: "large", value: strings.Repeat("x", 1<<20), into: func() interface{} { return new(string) }},
+		{name: "pattern key", key: `a*b?[c]\d`, value: true, into: func() interface{} { return new(bool) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := h.New(t)
+
+			if err := s.Set(ctx, tt.key, tt.value, state.NoExpiry); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			got := tt.into()
+			if err := s.Get(ctx, tt.key, got); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got := reflect.ValueOf(got).Elem().Interface(); !reflect.DeepEqual(got, tt.value) {
+				t.Errorf("Get() = %v, want %v", got, tt.value)
+			}
+		})
+	}
+
+	t.Run("unmarshalable", func(t *testing.T) {
+		s := h.New(t)
+		if err := s.Set(context.Background(), "func", func() {}, state.NoExpiry); err == nil {
+			t.Error("Set() error = nil, want marshal error")
+		}
+	})
+}
+
+func testListDelete(t *testing.T, h Harness) {
+	ctx := context.Background()
+	s := h.New(t)
+
+	keys := []string{"rotator/1/rotation", "rotator/2/rotation", "rotator*/3", "poll/1", "rotatorx"}
+	for _, key := range keys {
+		s.Set(ctx, key, key, state.NoExpiry)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "rotator/", want: []string{"rotator/1/rotation", "rotator/2/rotation"}},
+		{prefix: "rotator*", want: []string{"rotator*/3"}},
+		{prefix: "none/", want: []string{}},
+		{prefix: "", want: []string{"poll/1", "rotator*/3", "rotator/1/rotation", "rotator/2/rotation", "rotatorx"}},
+	}
+	for _, tt := range tests {
+		got, err := s.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q) error = %v", tt.prefix, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+
+	if err := s.Delete(ctx, "rotator/1/rotation"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := s.Get(ctx, "rotator/1/rotation", new(string)); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, state.ErrNotFound)
+	}
+	if got, _ := s.List(ctx, "rotator/"); !reflect.DeepEqual(got, []string{"rotator/2/rotation"}) {
+		t.Errorf("List() after Delete() = %v", got)
+	}
+}
+
+func testExpiry(t *testing.T, h Harness) {
+	const ttl = 50 * time.Millisecond
+
+	ctx := context.Background()
+	s := h.New(t)
+
+	for _, key := range []string{"short", "kept", "forever", "refreshed"} {
+		if err := s.Set(ctx, key, key, ttl); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	// Rewriting with KeepTTL must not extend the expiry, while NoExpiry removes it
+	s.Update(ctx, "kept", new(string), state.KeepTTL, func() error { return nil })
+	s.Set(ctx, "forever", "forever", state.NoExpiry)
+	s.Set(ctx, "refreshed", "refreshed", time.Hour)
+
+	h.Elapse(ttl * 2)
+
+	for key, wantFound := range map[string]bool{"short": false, "kept": false, "forever": true, "refreshed": true} {
+		err := s.Get(ctx, key, new(string))
+		if found := err == nil; found != wantFound {
+			t.Errorf("Get(%q) error = %v, want found %v", key, err, wantFound)
+		}
+	}
+
+	got, err := s.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []string{"forever", "refreshed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func testWatch(t *testing.T, h Harness) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := h.New(t)
+
+	events, err := s.Watch(ctx, "watched/")
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	s.Set(ctx, "watched/1", 1, state.NoExpiry)
+	s.Set(ctx, "ignored/1", 1, state.NoExpiry)
+	s.Update(ctx, "watched/2", new(int), state.NoExpiry, func() error { return nil })
+	s.Delete(ctx, "watched/1")
+
+	want := []state.Event{
+		{Key: "watched/1"},
+		{Key: "watched/2"},
+		{Key: "watched/1", Deleted: true},
+	}
+	for _, want := range want {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("Watch() event = %+v, want %+v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Watch() timed out waiting for %+v", want)
+		}
+	}
+
+	cancel()
+	for {
+		select {
+		case got, ok := <-events:
+			if !ok {
+				return
+			}
+			t.Errorf("Watch() unexpected event = %+v", got)
+		case <-time.After(time.Second):
+			t.Fatal("Watch() channel not closed after cancel")
+		}
+	}
+}
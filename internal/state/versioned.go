@@ -0,0 +1,244 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// Migration upgrades a stored value from one schema version to the next.
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// Migrations is a registry of schema upgrades, grouped by the key pattern (in
+// path.Match syntax, e.g. "guild/*/rotator/*/rotation") that they apply to.
+// The current schema version for a key is the number of migrations registered
+// for the first pattern it matches.
+type Migrations struct {
+	mu      sync.Mutex
+	schemas []schema
+}
+
+type schema struct {
+	pattern    string
+	migrations []Migration
+}
+
+// DefaultMigrations is the registry populated by packages at init time.
+var DefaultMigrations = &Migrations{}
+
+// Register appends the next migration for keys matching pattern.
+func (m *Migrations) Register(pattern string, fn Migration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("invalid migration pattern %q: %s", pattern, err))
+	}
+
+	for i := range m.schemas {
+		if m.schemas[i].pattern == pattern {
+			m.schemas[i].migrations = append(m.schemas[i].migrations, fn)
+			return
+		}
+	}
+
+	m.schemas = append(m.schemas, schema{pattern: pattern, migrations: []Migration{fn}})
+}
+
+func (m *Migrations) forKey(key string) []Migration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.schemas {
+		if ok, _ := path.Match(s.pattern, key); ok {
+			return s.migrations
+		}
+	}
+
+	return nil
+}
+
+// Versioned is a Backend decorator that stores every value inside an envelope
+// recording its schema version, and upgrades older values as they're read.
+// Values written before versioning was introduced are treated as version 0.
+type Versioned struct {
+	Backend
+	migrations *Migrations
+}
+
+type envelope struct {
+	Version int             `json:"$version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// errUnchanged aborts an Update when a value is already at the current version.
+var errUnchanged = errors.New("value unchanged")
+
+func NewVersioned(b Backend, migrations *Migrations) *Versioned {
+	return &Versioned{Backend: b, migrations: migrations}
+}
+
+func (s *Versioned) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	env, err := s.wrap(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.Backend.Set(ctx, key, env, ttl)
+}
+
+func (s *Versioned) Get(ctx context.Context, key string, value interface{}) error {
+	raw := json.RawMessage{}
+	if err := s.Backend.Get(ctx, key, &raw); err != nil {
+		return err
+	}
+
+	data, _, err := s.unwrap(key, raw)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+func (s *Versioned) Update(ctx context.Context, key string, value interface{}, ttl time.Duration, fn func() error) error {
+	raw := json.RawMessage{}
+	return s.Backend.Update(ctx, key, &raw, ttl, func() error {
+		resetValue(value)
+		if raw != nil {
+			data, _, err := s.unwrap(key, raw)
+			if err != nil {
+				return err
+			}
+
+			if err := json.Unmarshal(data, value); err != nil {
+				return err
+			}
+		}
+
+		if err := fn(); err != nil {
+			return err
+		}
+
+		env, err := s.wrap(key, value)
+		if err != nil {
+			return err
+		}
+
+		raw, err = json.Marshal(env)
+		return err
+	})
+}
+
+// Migrate upgrades the stored value at key to the current schema version,
+// preserving its expiry. It reports whether the value needed upgrading.
+func (s *Versioned) Migrate(ctx context.Context, key string) (bool, error) {
+	raw := json.RawMessage{}
+	err := s.Backend.Update(ctx, key, &raw, KeepTTL, func() error {
+		if raw == nil {
+			return ErrNotFound
+		}
+
+		data, upgraded, err := s.unwrap(key, raw)
+		if err != nil {
+			return err
+		} else if !upgraded {
+			return errUnchanged
+		}
+
+		raw, err = json.Marshal(envelope{Version: len(s.migrations.forKey(key)), Data: data})
+		return err
+	})
+
+	switch {
+	case errors.Is(err, errUnchanged):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("unable to migrate %q: %w", key, err)
+	default:
+		return true, nil
+	}
+}
+
+// MigrateAll upgrades every stored value, returning the number of values that
+// were rewritten.
+func (s *Versioned) MigrateAll(ctx context.Context) (int, error) {
+	keys, err := s.List(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("unable to list keys: %w", err)
+	}
+
+	var migrated int
+	for _, key := range keys {
+		ok, err := s.Migrate(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted or expired while we were working
+			continue
+		} else if err != nil {
+			return migrated, err
+		} else if ok {
+			migrated++
+		}
+	}
+
+	return migrated, nil
+}
+
+func (s *Versioned) wrap(key string, value interface{}) (envelope, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return envelope{}, err
+	}
+
+	return envelope{Version: len(s.migrations.forKey(key)), Data: data}, nil
+}
+
+// unwrap extracts the data from a stored value, applying any migrations needed
+// to bring it up to the current version. It reports whether the stored value is
+// out of date, either because migrations were applied or because it predates
+// the envelope.
+func (s *Versioned) unwrap(key string, raw json.RawMessage) (json.RawMessage, bool, error) {
+	version, data := 0, raw
+	env, enveloped := parseEnvelope(raw)
+	if enveloped {
+		version, data = env.Version, env.Data
+	}
+
+	migrations := s.migrations.forKey(key)
+	if version > len(migrations) {
+		return nil, false, fmt.Errorf("%q has schema version %d, newer than the supported version %d", key, version, len(migrations))
+	}
+
+	for v := version; v < len(migrations); v++ {
+		var err error
+		if data, err = migrations[v](data); err != nil {
+			return nil, false, fmt.Errorf("unable to migrate %q from version %d: %w", key, v, err)
+		}
+	}
+
+	return data, !enveloped || version < len(migrations), nil
+}
+
+// parseEnvelope reports whether raw is an envelope rather than a value stored
+// before versioning was introduced.
+func parseEnvelope(raw json.RawMessage) (envelope, bool) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil || len(fields) != 2 {
+		return envelope{}, false
+	}
+
+	env := envelope{}
+	if _, ok := fields["$version"]; !ok {
+		return envelope{}, false
+	} else if _, ok := fields["data"]; !ok {
+		return envelope{}, false
+	} else if err := json.Unmarshal(raw, &env); err != nil {
+		return envelope{}, false
+	}
+
+	return env, true
+}
@@ -0,0 +1,142 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type versionedUser struct {
+	ID          string
+	DisplayName string
+}
+
+func testMigrations() *Migrations {
+	m := &Migrations{}
+
+	// v0 -> v1: users were stored as bare IDs
+	m.Register("users/*", func(data json.RawMessage) (json.RawMessage, error) {
+		var id string
+		if err := json.Unmarshal(data, &id); err != nil {
+			return nil, err
+		}
+		return json.Marshal(versionedUser{ID: id})
+	})
+
+	// v1 -> v2: users gained a display name
+	m.Register("users/*", func(data json.RawMessage) (json.RawMessage, error) {
+		user := versionedUser{}
+		if err := json.Unmarshal(data, &user); err != nil {
+			return nil, err
+		}
+		user.DisplayName = "user " + user.ID
+		return json.Marshal(user)
+	})
+
+	return m
+}
+
+func TestVersioned_Get(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		want    versionedUser
+		wantErr bool
+	}{
+		{
+			name:   "legacy",
+			stored: `"123"`,
+			want:   versionedUser{ID: "123", DisplayName: "user 123"},
+		},
+		{
+			name:   "v1",
+			stored: `{"$version":1,"data":{"ID":"123"}}`,
+			want:   versionedUser{ID: "123", DisplayName: "user 123"},
+		},
+		{
+			name:   "current",
+			stored: `{"$version":2,"data":{"ID":"123","DisplayName":"Custom"}}`,
+			want:   versionedUser{ID: "123", DisplayName: "Custom"},
+		},
+		{
+			name:    "newer",
+			stored:  `{"$version":3,"data":{"ID":"123"}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			inner := NewMemory()
+			inner.Set(ctx, "users/1", json.RawMessage(tt.stored), NoExpiry)
+
+			s := NewVersioned(inner, testMigrations())
+			got := versionedUser{}
+			if err := s.Get(ctx, "users/1", &got); (err != nil) != tt.wantErr {
+				t.Fatalf("Versioned.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Versioned.Get() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersioned_Set(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemory()
+	s := NewVersioned(inner, testMigrations())
+
+	s.Set(ctx, "users/1", versionedUser{ID: "1"}, NoExpiry)
+	s.Set(ctx, "other", "value", NoExpiry)
+
+	tests := map[string]string{
+		"users/1": `{"$version":2,"data":{"ID":"1","DisplayName":""}}`,
+		"other":   `{"$version":0,"data":"value"}`,
+	}
+	for key, want := range tests {
+		got := json.RawMessage{}
+		inner.Get(ctx, key, &got)
+		if string(got) != want {
+			t.Errorf("stored %s = %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestVersioned_MigrateAll(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemory()
+	inner.Set(ctx, "users/1", "1", NoExpiry)
+	inner.Set(ctx, "users/2", json.RawMessage(`{"$version":1,"data":{"ID":"2"}}`), NoExpiry)
+	inner.Set(ctx, "users/3", json.RawMessage(`{"$version":2,"data":{"ID":"3","DisplayName":"Three"}}`), NoExpiry)
+	inner.Set(ctx, "other", "value", NoExpiry)
+
+	s := NewVersioned(inner, testMigrations())
+	migrated, err := s.MigrateAll(ctx)
+	if err != nil {
+		t.Fatalf("Versioned.MigrateAll() error = %v", err)
+	}
+	if migrated != 3 {
+		t.Errorf("Versioned.MigrateAll() = %d, want 3", migrated)
+	}
+
+	want := map[string]string{
+		"users/1": `{"$version":2,"data":{"ID":"1","DisplayName":"user 1"}}`,
+		"users/2": `{"$version":2,"data":{"ID":"2","DisplayName":"user 2"}}`,
+		"users/3": `{"$version":2,"data":{"ID":"3","DisplayName":"Three"}}`,
+		"other":   `{"$version":0,"data":"value"}`,
+	}
+	for key, want := range want {
+		got := json.RawMessage{}
+		inner.Get(ctx, key, &got)
+		if string(got) != want {
+			t.Errorf("stored %s = %s, want %s", key, got, want)
+		}
+	}
+
+	// Running again should be a no-op
+	if migrated, _ := s.MigrateAll(ctx); migrated != 0 {
+		t.Errorf("Versioned.MigrateAll() second run = %d, want 0", migrated)
+	}
+}
@@ -2,66 +2,326 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/go-redis/redis/v8"
 	"github.com/team-dumpster-fire/lil-dumpster/cmd"
 	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
 )
 
+// shutdownTimeout is how long in-flight events may take to finish once the bot
+// has been asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+const (
+	// clientKey is written to check the connection to Redis on startup.
+	clientKey = "client"
+
+	// leaderPrefix holds the leases of elected replicas.
+	leaderPrefix = "leader/"
+)
+
 func main() {
 	// Handle signal interrupts.
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	defer cancel()
 
-	token := os.Getenv("DISCORD_TOKEN")
+	newSession := func(token string) (*discordgo.Session, error) {
+		return discordgo.New("Bot " + token)
+	}
+	if err := run(ctx, os.LookupEnv, os.Args[1:], newSession); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run configures the bot from the environment and runs it until ctx is done,
+// or runs the maintenance command given in args. Sessions are created with
+// newSession, which tests point at a stand-in for Discord.
+func run(ctx context.Context, lookupEnv func(string) (string, bool), args []string, newSession func(token string) (*discordgo.Session, error)) error {
+	backend, err := configureBackend(ctx, lookupEnv)
+	if err != nil {
+		return err
+	}
+	if closer, ok := backend.(io.Closer); ok {
+		defer closer.Close()
+	}
+	layered, encrypted, err := configureLayers(ctx, lookupEnv, backend)
+	if err != nil {
+		return err
+	}
+	store := state.NewVersioned(layered, state.DefaultMigrations)
+
+	// Run any one-shot maintenance commands instead of the bot
+	if len(args) > 0 {
+		return runCommand(ctx, store, encrypted, args[0], args[1:])
+	}
+
+	token, _ := lookupEnv("DISCORD_TOKEN")
 	if token == "" {
-		log.Fatal("Please set a DISCORD_TOKEN environment variable to your bot token")
+		return errors.New("please set a DISCORD_TOKEN environment variable to your bot token")
 	}
 
-	b, err := discordgo.New("Bot " + token)
+	opts := []cmd.Option{}
+	if grace, ok := lookupEnv("GUILD_PURGE_GRACE"); ok {
+		d, err := time.ParseDuration(grace)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid GUILD_PURGE_GRACE %q: must be a duration such as 72h", grace)
+		}
+		opts = append(opts, cmd.WithPurgeGrace(d))
+	}
+	if value, ok := lookupEnv("COMMANDS_GLOBAL"); ok {
+		global, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid COMMANDS_GLOBAL %q: must be true or false", value)
+		}
+		if global {
+			opts = append(opts, cmd.WithGlobalCommands())
+		}
+	}
+
+	// Interactions may be received over HTTP instead of the gateway
+	addr, serveHTTP := lookupEnv("INTERACTIONS_ADDR")
+	var publicKey ed25519.PublicKey
+	if serveHTTP {
+		value, _ := lookupEnv("DISCORD_PUBLIC_KEY")
+		key, err := hex.DecodeString(value)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return errors.New("please set a DISCORD_PUBLIC_KEY environment variable to your application's public key")
+		}
+		publicKey = key
+	}
+
+	b, err := newSession(token)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer b.Close()
 
-	commands := cmd.NewCommands(b, configureBackend(ctx))
-	commands.AddHandlers()
+	commands := cmd.NewCommands(b, store, opts...)
+	if !serveHTTP {
+		commands.AddHandlers()
+	}
+
+	// Only one replica should perform background work at a time
+	campaignCtx, stopCampaign := context.WithCancel(ctx)
+	campaignDone := make(chan struct{})
+	go func() {
+		commands.Run(campaignCtx)
+		close(campaignDone)
+	}()
+	defer func() {
+		stopCampaign()
+		<-campaignDone
+	}()
 
 	// Begin listening for events
-	err = b.Open()
-	if err != nil {
-		log.Fatal("Could not connect to discord", err)
+	var server *http.Server
+	serveErr := make(chan error, 1)
+	if serveHTTP {
+		if err := commands.StartHTTP(ctx); err != nil {
+			return fmt.Errorf("could not connect to discord: %w", err)
+		}
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("could not listen for interactions: %w", err)
+		}
+		server = &http.Server{
+			Handler:           commands.InteractionsHandler(publicKey),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			serveErr <- server.Serve(listener)
+		}()
+		fmt.Printf("Bot is now serving interactions at %s. Check out Discord!\n", listener.Addr())
+	} else {
+		if err := b.Open(); err != nil {
+			return fmt.Errorf("could not connect to discord: %w", err)
+		}
+		fmt.Println("Bot is now running. Check out Discord!")
 	}
 
 	// Wait until the application is shutting down
-	fmt.Println("Bot is now running. Check out Discord!")
-	<-ctx.Done()
-	b.Close()
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		return fmt.Errorf("interactions endpoint failed: %w", err)
+	}
+
+	// Give interactions already underway a chance to finish
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if server != nil {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Gave up waiting for interaction requests:", err)
+		}
+	} else {
+		b.Close()
+	}
+	if err := commands.Shutdown(shutdownCtx); err != nil {
+		log.Println("Gave up waiting for in-flight events:", err)
+	}
+
+	return nil
 }
 
-func configureBackend(ctx context.Context) state.Backend {
+func configureBackend(ctx context.Context, lookupEnv func(string) (string, bool)) (state.Backend, error) {
 	var store state.Backend = state.NewMemory()
-	if host, ok := os.LookupEnv("REDIS_HOST"); ok {
-		var port string
-		if host, port, ok = strings.Cut(host, ":"); !ok {
-			if port, ok = os.LookupEnv("REDIS_PORT"); !ok {
-				port = "4646"
+
+	redisCfg, err := loadRedisConfig(lookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis configuration: %w", err)
+	}
+
+	if redisCfg != nil {
+		store = state.NewRedisClient(redisCfg.Client())
+		if err := store.Set(ctx, clientKey, "lil-dumpster", time.Minute); err != nil {
+			return nil, fmt.Errorf("unable to connect to Redis backend at %s: %w", redisCfg, err)
+		}
+	} else if path, ok := lookupEnv("STATE_FILE"); ok {
+		file, err := state.NewFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open state file at %s: %w", path, err)
+		}
+		store = file
+	}
+
+	return store, nil
+}
+
+// configureLayers wraps the backend with the optional encryption and caching
+// decorators. The encryption layer is also returned, or nil if not enabled.
+func configureLayers(ctx context.Context, lookupEnv func(string) (string, bool), backend state.Backend) (state.Backend, *state.Encrypted, error) {
+	_, remote := backend.(*state.Redis)
+
+	var encrypted *state.Encrypted
+	if value, ok := lookupEnv("STATE_ENCRYPTION_KEYS"); ok {
+		keys := [][]byte{}
+		for i, encoded := range strings.Split(value, ",") {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid STATE_ENCRYPTION_KEYS entry %d: %w", i+1, err)
+			}
+			keys = append(keys, key)
+		}
+
+		var err error
+		if encrypted, err = state.NewEncrypted(backend, keys...); err != nil {
+			return nil, nil, fmt.Errorf("invalid STATE_ENCRYPTION_KEYS: %w", err)
+		}
+		backend = encrypted
+	}
+
+	// Keep recently used values close at hand to save round trips to Redis
+	if remote {
+		size, maxAge := 1000, time.Minute
+		if value, ok := lookupEnv("STATE_CACHE_SIZE"); ok {
+			var err error
+			if size, err = strconv.Atoi(value); err != nil || size < 0 {
+				return nil, nil, fmt.Errorf("invalid STATE_CACHE_SIZE %q: must be a non-negative integer", value)
+			}
+		}
+		if value, ok := lookupEnv("STATE_CACHE_MAX_AGE"); ok {
+			var err error
+			if maxAge, err = time.ParseDuration(value); err != nil || maxAge <= 0 {
+				return nil, nil, fmt.Errorf("invalid STATE_CACHE_MAX_AGE %q: must be a positive duration such as 1m", value)
+			}
+		}
+
+		if size > 0 {
+			cache, err := state.NewCache(ctx, backend, size, maxAge)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to set up the state cache: %w", err)
+			}
+			backend = cache
+		}
+	}
+
+	return backend, encrypted, nil
+}
+
+// exportable reports whether a key holds data worth moving to another
+// deployment. The connection check and leader leases only make sense in the
+// deployment that wrote them, and a restored lease would hold up the election.
+func exportable(key string) bool {
+	return key != clientKey && !strings.HasPrefix(key, leaderPrefix)
+}
+
+// runCommand executes one of the maintenance subcommands against the store.
+func runCommand(ctx context.Context, store *state.Versioned, encrypted *state.Encrypted, name string, args []string) error {
+	switch name {
+	case "rekey":
+		if encrypted == nil {
+			return errors.New("STATE_ENCRYPTION_KEYS must be set to re-encrypt values")
+		}
+
+		rekeyed, err := encrypted.Rekey(ctx)
+		if err != nil {
+			return fmt.Errorf("re-encryption failed after rewriting %d values: %w", rekeyed, err)
+		}
+		fmt.Fprintf(os.Stderr, "Re-encrypted %d values with the primary key\n", rekeyed)
+	case "migrate":
+		migrated, err := store.MigrateAll(ctx)
+		if err != nil {
+			return fmt.Errorf("migration failed after upgrading %d values: %w", migrated, err)
+		}
+		fmt.Fprintf(os.Stderr, "Upgraded %d values to the current schema\n", migrated)
+	case "export":
+		// Writes to the named file, or stdout if omitted
+		w, f := os.Stdout, (*os.File)(nil)
+		if len(args) > 0 && args[0] != "-" {
+			var err error
+			if f, err = os.Create(args[0]); err != nil {
+				return fmt.Errorf("unable to create export file: %w", err)
+			}
+			w = f
+		}
+
+		exported, err := state.Export(ctx, store, w, "", exportable)
+		if f != nil {
+			// Closing reports writes that failed to reach the disk
+			if closeErr := f.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("unable to write export file: %w", closeErr)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d values\n", exported)
+	case "import":
+		// Reads from the named file, or stdin if omitted
+		r := os.Stdin
+		if len(args) > 0 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("unable to open import file: %w", err)
 			}
+			defer f.Close()
+			r = f
 		}
 
-		addr := fmt.Sprintf("%s:%s", host, port)
-		store = state.NewRedis(&redis.Options{Addr: addr})
-		if err := store.Set(ctx, "client", "lil-dumpster"); err != nil {
-			log.Fatalf("Unable to connect to Redis backend at %s: %s", addr, err)
+		imported, err := state.Import(ctx, store, r)
+		if err != nil {
+			return fmt.Errorf("import failed after writing %d values: %w", imported, err)
 		}
+		fmt.Fprintf(os.Stderr, "Imported %d values\n", imported)
+	default:
+		return fmt.Errorf("unknown command %q. Available commands: migrate, rekey, export [file], import [file]", name)
 	}
 
-	return store
+	return nil
 }
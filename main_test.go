@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/discordtest"
+	"github.com/team-dumpster-fire/lil-dumpster/internal/state"
+)
+
+func Test_run(t *testing.T) {
+	srv := discordtest.NewServer(t)
+
+	guildID, rolesChannel, general := srv.NewID(), srv.NewID(), srv.NewID()
+	role := &discordgo.Role{ID: srv.NewID(), Name: "gamers"}
+	alice := &discordgo.Member{User: &discordgo.User{ID: srv.NewID(), Username: "alice"}}
+	bob := &discordgo.Member{User: &discordgo.User{ID: srv.NewID(), Username: "bob"}}
+	srv.AddGuild(&discordgo.Guild{
+		ID:   guildID,
+		Name: "dumpster",
+		Channels: []*discordgo.Channel{
+			{ID: rolesChannel, Name: "roles", Type: discordgo.ChannelTypeGuildText},
+			{ID: general, Name: "general", Type: discordgo.ChannelTypeGuildText},
+		},
+		Roles:   []*discordgo.Role{{ID: guildID, Name: "@everyone"}, role},
+		Members: []*discordgo.Member{alice, bob},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	env := map[string]string{"DISCORD_TOKEN": "test"}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- run(ctx, lookupEnv, nil, srv.NewSession)
+	}()
+
+	select {
+	case <-srv.Connected():
+	case err := <-result:
+		t.Fatalf("run() exited early: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("bot never connected to the gateway")
+	}
+
+	// Setup happens in the background once the guild is received
+	eventually(t, "commands registered", func() bool { return len(srv.Commands(guildID)) > 0 })
+	eventually(t, "roles message pinned", func() bool { return len(srv.Pinned(rolesChannel)) == 1 })
+
+	registered := map[string]bool{}
+	for _, cmd := range srv.Commands(guildID) {
+		registered[cmd.Name] = true
+	}
+	for _, name := range []string{"rotator", "role-add", "role-remove", "poll", "permissions"} {
+		if !registered[name] {
+			t.Errorf("command %q was not registered; got %v", name, registered)
+		}
+	}
+
+	moderator := *alice
+	moderator.Permissions = discordgo.PermissionManageChannels
+	flows := []struct {
+		name        string
+		interaction *discordgo.Interaction
+		want        string
+	}{
+		{
+			name: "rotator add",
+			interaction: command(guildID, general, &moderator, "rotator", &discordgo.ApplicationCommandInteractionDataOption{
+				Name: "add",
+				Type: discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "username", Type: discordgo.ApplicationCommandOptionUser, Value: bob.User.ID},
+				},
+			}),
+			want: bob.User.ID,
+		},
+		{
+			name: "rotator show",
+			interaction: command(guildID, general, bob, "rotator", &discordgo.ApplicationCommandInteractionDataOption{
+				Name: "show",
+				Type: discordgo.ApplicationCommandOptionSubCommand,
+			}),
+			want: bob.User.ID,
+		},
+		{
+			name: "rotator add without permission",
+			interaction: command(guildID, general, bob, "rotator", &discordgo.ApplicationCommandInteractionDataOption{
+				Name: "add",
+				Type: discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "username", Type: discordgo.ApplicationCommandOptionUser, Value: alice.User.ID},
+				},
+			}),
+			want: ":warning:",
+		},
+		{
+			name: "role-add",
+			interaction: command(guildID, general, bob, "role-add", &discordgo.ApplicationCommandInteractionDataOption{
+				Name:  "role-name",
+				Type:  discordgo.ApplicationCommandOptionString,
+				Value: "gamers",
+			}),
+			want: "gamers",
+		},
+	}
+	for _, tt := range flows {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+
+			resp, err := srv.Interact(ctx, tt.interaction)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Data == nil || !strings.Contains(resp.Data.Content, tt.want) {
+				t.Errorf("Interact() = %+v, want content containing %q", resp.Data, tt.want)
+			}
+		})
+	}
+
+	if got := srv.MemberRoles(guildID, bob.User.ID); len(got) != 1 || got[0] != role.ID {
+		t.Errorf("bob has roles %v, want [%s]", got, role.ID)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("run() = %v", err)
+		}
+	case <-time.After(shutdownTimeout + 5*time.Second):
+		t.Fatal("run() did not return after the context was cancelled")
+	}
+}
+
+func Test_run_interactions(t *testing.T) {
+	srv := discordtest.NewServer(t)
+	guildID, general := srv.NewID(), srv.NewID()
+	srv.AddGuild(&discordgo.Guild{
+		ID:       guildID,
+		Name:     "dumpster",
+		Channels: []*discordgo.Channel{{ID: general, Name: "general", Type: discordgo.ChannelTypeGuildText}},
+	})
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reserve an address for the interactions endpoint
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	env := map[string]string{
+		"DISCORD_TOKEN":      "test",
+		"INTERACTIONS_ADDR":  addr,
+		"DISCORD_PUBLIC_KEY": hex.EncodeToString(publicKey),
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- run(ctx, lookupEnv, nil, srv.NewSession)
+	}()
+
+	eventually(t, "commands registered", func() bool { return len(srv.Commands(guildID)) > 0 })
+
+	post := func(i *discordgo.Interaction) *discordgo.InteractionResponse {
+		t.Helper()
+
+		body, err := json.Marshal(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+		req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(privateKey, append([]byte(timestamp), body...))))
+
+		var resp *http.Response
+		eventually(t, "interactions endpoint", func() bool {
+			resp, err = http.DefaultClient.Do(req)
+			return err == nil
+		})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want 200", resp.StatusCode)
+		}
+		ret := &discordgo.InteractionResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+
+	if resp := post(&discordgo.Interaction{ID: srv.NewID(), Type: discordgo.InteractionPing, Token: "ping"}); resp.Type != discordgo.InteractionResponsePong {
+		t.Errorf("ping answered with %d, want pong", resp.Type)
+	}
+
+	member := &discordgo.Member{User: &discordgo.User{ID: srv.NewID(), Username: "alice"}}
+	show := command(guildID, general, member, "rotator", &discordgo.ApplicationCommandInteractionDataOption{
+		Name: "show",
+		Type: discordgo.ApplicationCommandOptionSubCommand,
+	})
+	show.ID, show.Token = srv.NewID(), "show"
+	if resp := post(show); resp.Data == nil || !strings.Contains(resp.Data.Content, "no users currently in rotation") {
+		t.Errorf("rotator show answered with %+v", resp.Data)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("run() = %v", err)
+		}
+	case <-time.After(shutdownTimeout + 5*time.Second):
+		t.Fatal("run() did not return after the context was cancelled")
+	}
+}
+
+func command(guildID, channelID string, member *discordgo.Member, name string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.Interaction {
+	return &discordgo.Interaction{
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   guildID,
+		ChannelID: channelID,
+		Member:    member,
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name:    name,
+			Options: opts,
+		},
+	}
+}
+
+// eventually fails the test if cond doesn't become true within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_runCommand_export(t *testing.T) {
+	ctx := context.Background()
+	store := state.NewVersioned(state.NewMemory(), state.DefaultMigrations)
+	for _, key := range []string{clientKey, "leader/background", "guild/1/rotator/2/rotation", "purge/1"} {
+		if err := store.Set(ctx, key, "value", state.NoExpiry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "export.json")
+	if err := runCommand(ctx, store, nil, "export", []string{path}); err != nil {
+		t.Fatalf("runCommand() error = %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	exported := struct {
+		Values map[string]json.RawMessage `json:"values"`
+	}{}
+	if err := json.NewDecoder(f).Decode(&exported); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for key := range exported.Values {
+		got = append(got, key)
+	}
+	sort.Strings(got)
+
+	if want := []string{"guild/1/rotator/2/rotation", "purge/1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exported keys = %v, want %v", got, want)
+	}
+}
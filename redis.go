@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisConfig describes how to connect to Redis, as read from the environment.
+type redisConfig struct {
+	opts    *redis.UniversalOptions
+	cluster bool
+}
+
+// loadRedisConfig builds a Redis configuration from the environment. The
+// connection is described by exactly one of:
+//
+//   - REDIS_URL, a redis:// or rediss:// URL including any credentials and DB
+//   - REDIS_HOST and optionally REDIS_PORT
+//   - REDIS_SENTINEL_ADDRS and REDIS_SENTINEL_MASTER for a Sentinel setup
+//   - REDIS_CLUSTER_ADDRS for a Redis Cluster
+//
+// REDIS_PASSWORD, REDIS_SENTINEL_PASSWORD, REDIS_DB, REDIS_DIAL_TIMEOUT,
+// REDIS_READ_TIMEOUT and REDIS_WRITE_TIMEOUT may be used to refine any of them.
+// A nil config is returned if Redis has not been configured at all.
+func loadRedisConfig(lookupEnv func(string) (string, bool)) (*redisConfig, error) {
+	env := func(key string) string {
+		value, _ := lookupEnv(key)
+		return strings.TrimSpace(value)
+	}
+
+	var configured []string
+	for _, key := range []string{"REDIS_URL", "REDIS_HOST", "REDIS_SENTINEL_ADDRS", "REDIS_CLUSTER_ADDRS"} {
+		if env(key) != "" {
+			configured = append(configured, key)
+		}
+	}
+
+	if len(configured) == 0 {
+		return nil, nil
+	} else if len(configured) > 1 {
+		return nil, fmt.Errorf("only one of %s may be set", strings.Join(configured, ", "))
+	}
+
+	cfg := &redisConfig{opts: &redis.UniversalOptions{}}
+	switch configured[0] {
+	case "REDIS_URL":
+		opt, err := redis.ParseURL(env("REDIS_URL"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+
+		cfg.opts = &redis.UniversalOptions{
+			Addrs:        []string{opt.Addr},
+			DB:           opt.DB,
+			Username:     opt.Username,
+			Password:     opt.Password,
+			DialTimeout:  opt.DialTimeout,
+			ReadTimeout:  opt.ReadTimeout,
+			WriteTimeout: opt.WriteTimeout,
+			TLSConfig:    opt.TLSConfig,
+		}
+	case "REDIS_HOST":
+		host, port, ok := strings.Cut(env("REDIS_HOST"), ":")
+		if !ok {
+			if port = env("REDIS_PORT"); port == "" {
+				port = "6379"
+			}
+		}
+
+		cfg.opts.Addrs = []string{fmt.Sprintf("%s:%s", host, port)}
+	case "REDIS_SENTINEL_ADDRS":
+		cfg.opts.Addrs = splitAddrs(env("REDIS_SENTINEL_ADDRS"))
+		cfg.opts.MasterName = env("REDIS_SENTINEL_MASTER")
+		cfg.opts.SentinelPassword = env("REDIS_SENTINEL_PASSWORD")
+		if cfg.opts.MasterName == "" {
+			return nil, errors.New("REDIS_SENTINEL_MASTER is required with REDIS_SENTINEL_ADDRS")
+		}
+	case "REDIS_CLUSTER_ADDRS":
+		cfg.opts.Addrs = splitAddrs(env("REDIS_CLUSTER_ADDRS"))
+		cfg.cluster = true
+	}
+
+	if password := env("REDIS_PASSWORD"); password != "" {
+		cfg.opts.Password = password
+	}
+
+	if db := env("REDIS_DB"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid REDIS_DB %q: must be a non-negative integer", db)
+		}
+		cfg.opts.DB = n
+	}
+
+	if cfg.cluster && cfg.opts.DB != 0 {
+		return nil, errors.New("redis clusters only support DB 0")
+	}
+
+	for key, timeout := range map[string]*time.Duration{
+		"REDIS_DIAL_TIMEOUT":  &cfg.opts.DialTimeout,
+		"REDIS_READ_TIMEOUT":  &cfg.opts.ReadTimeout,
+		"REDIS_WRITE_TIMEOUT": &cfg.opts.WriteTimeout,
+	} {
+		if value := env(key); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s %q: must be a positive duration such as 5s", key, value)
+			}
+			*timeout = d
+		}
+	}
+
+	for _, addr := range cfg.opts.Addrs {
+		if _, port, ok := strings.Cut(addr, ":"); !ok || port == "" {
+			return nil, fmt.Errorf("invalid redis address %q: expected host:port", addr)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Client creates a Redis client of the appropriate flavor for the config.
+func (c *redisConfig) Client() redis.UniversalClient {
+	switch {
+	case c.cluster:
+		return redis.NewClusterClient(c.opts.Cluster())
+	case c.opts.MasterName != "":
+		return redis.NewFailoverClient(c.opts.Failover())
+	default:
+		return redis.NewClient(c.opts.Simple())
+	}
+}
+
+// String describes the configured Redis servers without exposing credentials.
+func (c *redisConfig) String() string {
+	ret := strings.Join(c.opts.Addrs, ",")
+	if c.opts.MasterName != "" {
+		ret = fmt.Sprintf("%s (sentinel master %s)", ret, c.opts.MasterName)
+	} else if c.cluster {
+		ret += " (cluster)"
+	}
+
+	return ret
+}
+
+func splitAddrs(addrs string) []string {
+	ret := []string{}
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			ret = append(ret, addr)
+		}
+	}
+
+	return ret
+}